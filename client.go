@@ -1,10 +1,14 @@
 package warc
 
 import (
+	"log/slog"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/paulbellamy/ratecounter"
 )
 
 type Error struct {
@@ -13,12 +17,16 @@ type Error struct {
 }
 
 type HTTPClientSettings struct {
-	RotatorSettings       *RotatorSettings
-	Proxy                 string
-	TempDir               string
-	DNSServer             string
-	DiscardHook           DiscardHook
-	DNSServers            []string
+	RotatorSettings *RotatorSettings
+	Proxy           string
+	TempDir         string
+	DNSServer       string
+	DiscardHook     DiscardHook
+	DNSServers      []string
+	// AcceptEncodings is the list of content-codings advertised in the Accept-Encoding
+	// header of every request, default is DefaultAcceptEncodings. When DecompressBody is
+	// set, they must all be part of SupportedContentEncodings.
+	AcceptEncodings       []string
 	DedupeOptions         DedupeOptions
 	DialTimeout           time.Duration
 	ResponseHeaderTimeout time.Duration
@@ -37,13 +45,30 @@ type HTTPClientSettings struct {
 	DisableIPv4           bool
 	DisableIPv6           bool
 	IPv6AnyIP             bool
+	// ArchiveFailures writes the exchanges that couldn't be captured, with a metadata
+	// record describing the error, instead of dropping them. See failure.go.
+	ArchiveFailures bool
+	// EventHandler, if set, is called synchronously with every Event emitted by the
+	// client, it must not block.
+	EventHandler func(*Event)
+	// EventsBufferSize is the capacity of the Events and ErrChan channels, default
+	// is DefaultEventsBufferSize. Events are dropped and counted when they are full.
+	EventsBufferSize int
+	// Metrics receives the measurements of the capture and of the writers of the
+	// rotator, see metrics.go. Use NewCaptureMetrics to expose them to Prometheus.
+	Metrics Metrics
+	// Logger receives debug-level traces of DNS resolutions, dials, TLS handshakes,
+	// captures, deduplication decisions and, unless RotatorSettings has its own,
+	// file rotations. Nothing is logged by default.
+	Logger *slog.Logger
 }
 
 type CustomHTTPClient struct {
 	interfacesWatcherStop    chan bool
 	WaitGroup                *WaitGroupWithCount
 	dedupeHashTable          *sync.Map
-	ErrChan                  chan *Error
+	conditionalHashTable     *sync.Map
+	validatedHashTable       *sync.Map
 	WARCWriter               chan *RecordBatch
 	interfacesWatcherStarted chan bool
 	http.Client
@@ -54,12 +79,34 @@ type CustomHTTPClient struct {
 	TLSHandshakeTimeout    time.Duration
 	MaxReadBeforeTruncate  int
 	verifyCerts            bool
+	archiveFailures        bool
 	FullOnDisk             bool
 	closeDNSCache          func()
 	// MaxRAMUsageFraction is the fraction of system RAM above which we'll force spooling to disk. For example, 0.5 = 50%.
 	// If set to <= 0, the default value is DefaultMaxRAMUsageFraction.
 	MaxRAMUsageFraction float64
 	randomLocalIP       bool
+	eventHandler        func(*Event)
+	metrics             Metrics
+	logger              *slog.Logger
+	droppedEvents       atomic.Uint64
+	droppedErrors       atomic.Uint64
+	ipv4                *availableIPs
+	ipv6                *availableIPs
+	cdxHTTPClient       *http.Client
+	rotatorSettings     *RotatorSettings
+	// DataTotal counts the bytes of record content written by the rotator of the client
+	DataTotal *ratecounter.Counter
+	// LocalDedupeTotal and RemoteDedupeTotal count the bytes saved by writing revisit records
+	LocalDedupeTotal  *ratecounter.Counter
+	RemoteDedupeTotal *ratecounter.Counter
+	// Events receives an Event for every error of the capture, see events.go
+	Events chan *Event
+	// ErrChan receives the errors of the capture.
+	//
+	// Deprecated: use Events or HTTPClientSettings.EventHandler, which tell the
+	// kind of each error. ErrChan is buffered and errors are dropped when it is full.
+	ErrChan chan *Error
 }
 
 func (c *CustomHTTPClient) Close() error {
@@ -79,6 +126,7 @@ func (c *CustomHTTPClient) Close() error {
 
 	wg.Wait()
 	close(c.ErrChan)
+	close(c.Events)
 
 	if c.randomLocalIP {
 		c.interfacesWatcherStop <- true
@@ -90,6 +138,12 @@ func (c *CustomHTTPClient) Close() error {
 	return nil
 }
 
+// RotateWARCFiles closes the WARC files being written, unless they have no
+// record yet, and opens new ones. It returns once the files are closed.
+func (c *CustomHTTPClient) RotateWARCFiles() {
+	c.rotatorSettings.Rotate()
+}
+
 func NewWARCWritingHTTPClient(HTTPClientSettings HTTPClientSettings) (httpClient *CustomHTTPClient, err error) {
 	httpClient = new(CustomHTTPClient)
 
@@ -98,13 +152,20 @@ func NewWARCWritingHTTPClient(HTTPClientSettings HTTPClientSettings) (httpClient
 	if httpClient.randomLocalIP {
 		httpClient.interfacesWatcherStop = make(chan bool)
 		httpClient.interfacesWatcherStarted = make(chan bool)
-		go httpClient.getAvailableIPs(HTTPClientSettings.IPv6AnyIP)
+		httpClient.ipv4 = &availableIPs{}
+		httpClient.ipv6 = &availableIPs{AnyIP: HTTPClientSettings.IPv6AnyIP}
+		go httpClient.getAvailableIPs()
 		<-httpClient.interfacesWatcherStarted
 	}
 
 	// Toggle deduplication options and create map for deduplication records.
 	httpClient.dedupeOptions = HTTPClientSettings.DedupeOptions
 	httpClient.dedupeHashTable = new(sync.Map)
+	httpClient.conditionalHashTable = new(sync.Map)
+	httpClient.validatedHashTable = new(sync.Map)
+	httpClient.cdxHTTPClient = newCDXHTTPClient()
+	httpClient.LocalDedupeTotal = new(ratecounter.Counter)
+	httpClient.RemoteDedupeTotal = new(ratecounter.Counter)
 
 	// Set default deduplication threshold to 2048 bytes
 	if httpClient.dedupeOptions.SizeThreshold == 0 {
@@ -114,8 +175,18 @@ func NewWARCWritingHTTPClient(HTTPClientSettings HTTPClientSettings) (httpClient
 	// Set a hook to determine if we should discard a response
 	httpClient.DiscardHook = HTTPClientSettings.DiscardHook
 
-	// Create an error channel for sending WARC errors through
-	httpClient.ErrChan = make(chan *Error)
+	// Toggle the archiving of failed exchanges
+	httpClient.archiveFailures = HTTPClientSettings.ArchiveFailures
+
+	// Create the channels for sending capture errors through, they never block the capture
+	eventsBufferSize := HTTPClientSettings.EventsBufferSize
+	if eventsBufferSize <= 0 {
+		eventsBufferSize = DefaultEventsBufferSize
+	}
+
+	httpClient.eventHandler = HTTPClientSettings.EventHandler
+	httpClient.Events = make(chan *Event, eventsBufferSize)
+	httpClient.ErrChan = make(chan *Error, eventsBufferSize)
 
 	// Toggle verification of certificates
 	// InsecureSkipVerify expects the opposite of the verifyCerts flag, as such we flip it.
@@ -146,11 +217,34 @@ func NewWARCWritingHTTPClient(HTTPClientSettings HTTPClientSettings) (httpClient
 	// Configure the waitgroup
 	httpClient.WaitGroup = new(WaitGroupWithCount)
 
+	// Configure the metrics, the writers of the rotator report to the same ones unless it has its own
+	httpClient.metrics = HTTPClientSettings.Metrics
+	if httpClient.metrics == nil {
+		httpClient.metrics = noopMetrics{}
+	} else if HTTPClientSettings.RotatorSettings.Metrics == nil {
+		HTTPClientSettings.RotatorSettings.Metrics = httpClient.metrics
+	}
+
+	// Configure the logger, the writers of the rotator log to the same one unless it has its own
+	httpClient.logger = HTTPClientSettings.Logger
+	if httpClient.logger == nil {
+		httpClient.logger = slog.New(slog.DiscardHandler)
+	} else if HTTPClientSettings.RotatorSettings.Logger == nil {
+		HTTPClientSettings.RotatorSettings.Logger = httpClient.logger
+	}
+
+	// The client reports the bytes written by its rotator
+	if HTTPClientSettings.RotatorSettings.DataTotal == nil {
+		HTTPClientSettings.RotatorSettings.DataTotal = new(ratecounter.Counter)
+	}
+	httpClient.DataTotal = HTTPClientSettings.RotatorSettings.DataTotal
+
 	// Configure WARC writer
 	httpClient.WARCWriter, httpClient.WARCWriterDoneChannels, err = HTTPClientSettings.RotatorSettings.NewWARCRotator()
 	if err != nil {
 		return nil, err
 	}
+	httpClient.rotatorSettings = HTTPClientSettings.RotatorSettings
 
 	// Configure HTTP client
 	if !HTTPClientSettings.FollowRedirects {
@@ -201,7 +295,7 @@ func NewWARCWritingHTTPClient(HTTPClientSettings HTTPClientSettings) (httpClient
 		time.Sleep(1 * time.Second)
 	}
 
-	customTransport, err := newCustomTransport(customDialer, HTTPClientSettings.DecompressBody, HTTPClientSettings.TLSHandshakeTimeout)
+	customTransport, err := newCustomTransport(customDialer, HTTPClientSettings.DecompressBody, HTTPClientSettings.AcceptEncodings, HTTPClientSettings.TLSHandshakeTimeout)
 	if err != nil {
 		return nil, err
 	}
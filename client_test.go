@@ -9,6 +9,7 @@ import (
 	"crypto/x509/pkix"
 	"errors"
 	"io"
+	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
@@ -16,6 +17,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -860,8 +863,527 @@ func TestHTTPClientLocalDedupe(t *testing.T) {
 	}
 
 	// verify that the local dedupe count is correct
-	if LocalDedupeTotal.Value() != 26872 {
-		t.Fatalf("local dedupe total mismatch, expected: 26872 got: %d", LocalDedupeTotal.Value())
+	if httpClient.LocalDedupeTotal.Value() != 26872 {
+		t.Fatalf("local dedupe total mismatch, expected: 26872 got: %d", httpClient.LocalDedupeTotal.Value())
+	}
+}
+
+func TestHTTPClientLocalDedupeValidated(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		requests        int
+		hookLengths     []int64
+		errs            []error
+		errWg           sync.WaitGroup
+	)
+
+	fileBytes, err := os.ReadFile(path.Join("testdata", "image.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The third response has the same validators as the others, but another payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		body := fileBytes
+		if requests == 3 {
+			body = []byte(strings.ToUpper(string(fileBytes)))
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		DedupeOptions: DedupeOptions{
+			LocalDedupe: true,
+		},
+		DiscardHook: func(resp *http.Response) (bool, string) {
+			n, _ := io.Copy(io.Discard, resp.Body)
+			hookLengths = append(hookLengths, n)
+			return false, ""
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			errs = append(errs, err.Err)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		time.Sleep(time.Second)
+	}
+
+	httpClient.Close()
+	errWg.Wait()
+
+	// The body of the second response was only hashed, the hook got an empty body
+	if len(hookLengths) != 3 || hookLengths[0] != int64(len(fileBytes)) || hookLengths[1] != 0 {
+		t.Errorf("expected the hook to get the body of the first response only, got %v", hookLengths)
+	}
+
+	// The third capture fails, as its payload wasn't the expected one
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "differs from") {
+		t.Fatalf("expected a payload digest error for the third response, got %v", errs)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc.gz"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v (%v)", paths, err)
+	}
+
+	var types []string
+	headers, contents := readTestRecords(t, paths[0])
+	for i, header := range headers {
+		if header.Get("WARC-Type") != "response" && header.Get("WARC-Type") != "revisit" {
+			continue
+		}
+		types = append(types, header.Get("WARC-Type"))
+
+		if header.Get("WARC-Type") == "revisit" && strings.Contains(string(contents[i]), "<svg") {
+			t.Error("expected the revisit to only hold the HTTP headers")
+		}
+	}
+
+	if !slices.Equal(types, []string{"response", "revisit"}) {
+		t.Errorf("expected a response and a revisit, got %v", types)
+	}
+}
+
+func TestHTTPClientLocalDedupeLargerThan2MB(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+		err             error
+	)
+
+	// init test HTTP endpoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileBytes, err := os.ReadFile(path.Join("testdata", "2MB.jpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.WriteHeader(http.StatusOK)
+		w.Write(fileBytes)
+	}))
+	defer server.Close()
+
+	// init the HTTP client responsible for recording HTTP(s) requests / responses
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		DedupeOptions: DedupeOptions{
+			LocalDedupe: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feedbackChan := make(chan struct{}, 1)
+		req = req.WithContext(context.WithValue(req.Context(), "feedback", feedbackChan))
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		<-feedbackChan
+	}
+
+	httpClient.Close()
+
+	files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range files {
+		// The revisit record must only contain the HTTP headers
+		testFileSingleHashCheck(t, path, "sha1:2WGRFHHSLP26L36FH4ZYQQ5C6WSQAGT7", []string{"3096070", "129"}, 2, server.URL+"/")
+		testFileRevisitVailidity(t, path, "", "", false)
+	}
+}
+
+func TestHTTPClientDiscardHookReadingBody(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+		err             error
+	)
+
+	// init test HTTP endpoint, the body is chunked to make sure the hook gets the transfer-decoded payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("captcha "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("required"))
+	}))
+	defer server.Close()
+
+	// init the HTTP client responsible for recording HTTP(s) requests / responses
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		DiscardHook: func(resp *http.Response) (bool, string) {
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("DiscardHook failed to read the body: %s", err)
+			}
+
+			return string(body) == "captcha required", "captcha"
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	discarded := false
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			if discardErr, ok := err.Err.(*DiscardHookError); ok && discardErr.Reason == "captcha" {
+				discarded = true
+				continue
+			}
+
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	httpClient.Close()
+	errWg.Wait()
+
+	if !discarded {
+		t.Fatal("expected the response to be discarded by the hook")
+	}
+}
+
+func TestHTTPClientConditionalRequests(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+		err             error
+		etag            = `"33a64df551425fcc55e4d42a148795d9f25f89d4"`
+		lastModified    = "Wed, 21 Oct 2015 07:28:00 GMT"
+	)
+
+	// init test HTTP endpoint, answering 304 when the validators match
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fileBytes, err := os.ReadFile(path.Join("testdata", "image.svg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.WriteHeader(http.StatusOK)
+		w.Write(fileBytes)
+	}))
+	defer server.Close()
+
+	// init the HTTP client responsible for recording HTTP(s) requests / responses
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		DedupeOptions: DedupeOptions{
+			ConditionalRequests: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	expectedStatusCodes := []int{http.StatusOK, http.StatusNotModified}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", server.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feedbackChan := make(chan struct{}, 1)
+		req = req.WithContext(context.WithValue(req.Context(), "feedback", feedbackChan))
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != expectedStatusCodes[i] {
+			t.Fatalf("unexpected status code %d, expected %d", resp.StatusCode, expectedStatusCodes[i])
+		}
+
+		<-feedbackChan
+	}
+
+	httpClient.Close()
+
+	files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range files {
+		testFileHash(t, path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			response, revisit *Record
+			requests          []string
+		)
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer record.Content.Close()
+
+			switch record.Header.Get("WARC-Type") {
+			case "response":
+				response = record
+			case "revisit":
+				revisit = record
+			case "request":
+				requests = append(requests, readAllFromStart(t, record.Content))
+			}
+		}
+
+		if len(requests) != 2 || strings.Contains(requests[0], "If-None-Match") || !strings.Contains(requests[1], "If-None-Match: "+etag) {
+			t.Error("only the second request should have been conditional")
+		}
+
+		if response == nil || revisit == nil {
+			t.Fatalf("expected a response and a revisit record, got %v and %v", response, revisit)
+		}
+
+		if revisit.Header.Get("WARC-Profile") != "http://netpreserve.org/warc/1.1/revisit/server-not-modified" {
+			t.Errorf("unexpected WARC-Profile %s", revisit.Header.Get("WARC-Profile"))
+		}
+
+		if revisit.Header.Get("WARC-Refers-To") != response.Header.Get("WARC-Record-ID") {
+			t.Errorf("WARC-Refers-To doesn't match the prior capture: %s != %s", revisit.Header.Get("WARC-Refers-To"), response.Header.Get("WARC-Record-ID"))
+		}
+
+		if revisit.Header.Get("WARC-Refers-To-Date") != response.Header.Get("WARC-Date") {
+			t.Errorf("WARC-Refers-To-Date doesn't match the prior capture: %s != %s", revisit.Header.Get("WARC-Refers-To-Date"), response.Header.Get("WARC-Date"))
+		}
+
+		if !strings.HasPrefix(readAllFromStart(t, revisit.Content), "HTTP/1.1 304 Not Modified") {
+			t.Error("the revisit record should contain the 304 response")
+		}
+	}
+}
+
+func TestHTTPClientConditionalRequestsCanonicalURL(t *testing.T) {
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: defaultRotatorSettings(t),
+		DedupeOptions: DedupeOptions{
+			ConditionalRequests: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+	defer httpClient.Close()
+
+	// Captures are stored under their canonical WARC-Target-URI
+	httpClient.conditionalHashTable.Store("http://example.com/", revisitRecord{
+		responseUUID: "uuid",
+		targetURI:    "http://example.com/",
+		etag:         `"etag"`,
+	})
+
+	for _, rawURL := range []string{"http://Example.com:80", "http://example.com/", "http://EXAMPLE.com#fragment"} {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		httpClient.setConditionalHeaders(req)
+
+		if req.Header.Get("If-None-Match") != `"etag"` {
+			t.Errorf("%s: expected If-None-Match from the capture of http://example.com/, got %q", rawURL, req.Header.Get("If-None-Match"))
+		}
+	}
+}
+
+// readAllFromStart reads everything from r, from the start.
+func readAllFromStart(t *testing.T, r io.ReadSeeker) string {
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestHTTPClientWebDAVMethod(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(207)
+		w.Write([]byte("<multistatus xmlns=\"DAV:\"/>"))
+	}))
+	defer server.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{RotatorSettings: rotatorSettings})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	req, err := http.NewRequest("PROPFIND", server.URL+"/dav/?depth=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	httpClient.Close()
+	errWg.Wait()
+
+	files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if record.Header.Get("WARC-Type") == "request" || record.Header.Get("WARC-Type") == "response" {
+				if record.Header.Get("WARC-Target-URI") != server.URL+"/dav/?depth=1" {
+					t.Errorf("unexpected WARC-Target-URI %s", record.Header.Get("WARC-Target-URI"))
+				}
+				total++
+			}
+
+			record.Content.Close()
+		}
+	}
+
+	if total != 2 {
+		t.Fatalf("expected a request and a response record, got %d", total)
 	}
 }
 
@@ -947,8 +1469,8 @@ func TestHTTPClientRemoteDedupe(t *testing.T) {
 	}
 
 	// verify that the remote dedupe count is correct
-	if RemoteDedupeTotal.Value() != 55896 {
-		t.Fatalf("remote dedupe total mismatch, expected: 55896 got: %d", RemoteDedupeTotal.Value())
+	if httpClient.RemoteDedupeTotal.Value() != 55896 {
+		t.Fatalf("remote dedupe total mismatch, expected: 55896 got: %d", httpClient.RemoteDedupeTotal.Value())
 	}
 }
 
@@ -959,9 +1481,6 @@ func TestHTTPClientDedupeEmptyPayload(t *testing.T) {
 		err             error
 	)
 
-	// Reset counter to 0?
-	LocalDedupeTotal.Reset()
-
 	// init test HTTP endpoint
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Empty. This is intentional to mirror 3I42H3S6NNFQ2MSVX7XZKYAYSCX5QBYJ.
@@ -1021,8 +1540,8 @@ func TestHTTPClientDedupeEmptyPayload(t *testing.T) {
 	}
 
 	// verify that the local dedupe count is correct
-	if LocalDedupeTotal.Value() != 0 {
-		t.Fatalf("local dedupe total mismatch, expected: 0 got: %d", LocalDedupeTotal.Value())
+	if httpClient.LocalDedupeTotal.Value() != 0 {
+		t.Fatalf("local dedupe total mismatch, expected: 0 got: %d", httpClient.LocalDedupeTotal.Value())
 	}
 }
 
@@ -2105,3 +2624,199 @@ func generateTLSConfig() *tls.Config {
 		// plan to trust this certificate chain.
 	}
 }
+
+// lockedWriter serializes the writes of the loggers used concurrently by the client and its rotator.
+type lockedWriter struct {
+	mutex sync.Mutex
+	b     strings.Builder
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.b.Write(p)
+}
+
+func (w *lockedWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.b.String()
+}
+
+func TestHTTPClientLogger(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		logs            lockedWriter
+		errWg           sync.WaitGroup
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	httpClient.Close()
+	errWg.Wait()
+
+	for _, message := range []string{"msg=dialed", `msg="captured exchange"`, `msg="created WARC file"`, `msg="closed WARC file"`} {
+		if !strings.Contains(logs.String(), message) {
+			t.Errorf("expected %s in the logs, got:\n%s", message, logs.String())
+		}
+	}
+
+	if rotatorSettings.Logger != logger {
+		t.Error("the rotator should log to the logger of the client")
+	}
+}
+
+func TestHTTPClientsIndependent(t *testing.T) {
+	fileBytes, err := os.ReadFile(path.Join("testdata", "image.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(fileBytes)
+	}))
+	defer server.Close()
+
+	// Clients with different settings capture the same URL at the same time
+	settings := []struct {
+		compression  string
+		poolSize     int
+		localDedupe  bool
+		dedupedBytes int64
+	}{
+		{compression: "GZIP", poolSize: 1, localDedupe: true, dedupedBytes: 3 * 26872},
+		{compression: "ZSTD", poolSize: 1},
+		{compression: "", poolSize: 2},
+	}
+
+	var (
+		clients  = make([]*CustomHTTPClient, len(settings))
+		rotators = make([]*RotatorSettings, len(settings))
+		wg       sync.WaitGroup
+	)
+
+	for i, setting := range settings {
+		rotators[i] = defaultRotatorSettings(t)
+		rotators[i].Compression = setting.compression
+		rotators[i].WARCWriterPoolSize = setting.poolSize
+
+		clients[i], err = NewWARCWritingHTTPClient(HTTPClientSettings{
+			RotatorSettings: rotators[i],
+			DedupeOptions:   DedupeOptions{LocalDedupe: setting.localDedupe},
+		})
+		if err != nil {
+			t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+		}
+	}
+
+	for _, httpClient := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 4; i++ {
+				req, err := http.NewRequest("GET", server.URL, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				feedbackChan := make(chan struct{}, 1)
+				req = req.WithContext(context.WithValue(req.Context(), "feedback", feedbackChan))
+
+				resp, err := httpClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				<-feedbackChan
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for i, httpClient := range clients {
+		httpClient.Close()
+
+		if httpClient.LocalDedupeTotal.Value() != settings[i].dedupedBytes {
+			t.Errorf("client %d: local dedupe total mismatch, expected: %d got: %d", i, settings[i].dedupedBytes, httpClient.LocalDedupeTotal.Value())
+		}
+
+		if httpClient.DataTotal.Value() <= 0 {
+			t.Errorf("client %d: no data written", i)
+		}
+
+		files, err := filepath.Glob(rotators[i].OutputDirectory + "/*")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != settings[i].poolSize {
+			t.Errorf("client %d: expected %d files, got %d", i, settings[i].poolSize, len(files))
+		}
+
+		total := 0
+		for _, path := range files {
+			total += testFileSingleHashCheck(t, path, "sha1:UIRWL5DFIPQ4MX3D3GFHM2HCVU3TZ6I3", []string{"26872", "132"}, -1, server.URL+"/")
+		}
+
+		if total != 4 {
+			t.Errorf("client %d: expected 4 records, got %d", i, total)
+		}
+	}
+
+	// The local IPs of a client are configured by its own settings
+	anyIPClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{RotatorSettings: defaultRotatorSettings(t), RandomLocalIP: true, IPv6AnyIP: true})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+	defer anyIPClient.Close()
+
+	otherClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{RotatorSettings: defaultRotatorSettings(t), RandomLocalIP: true})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+	defer otherClient.Close()
+
+	if !anyIPClient.ipv6.AnyIP || otherClient.ipv6.AnyIP {
+		t.Error("IPv6AnyIP should only apply to the client it is set on")
+	}
+}
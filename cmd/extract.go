@@ -28,6 +28,13 @@ func extract(cmd *cobra.Command, files []string) {
 		return
 	}
 
+	logger, err := newLogger(cmd)
+	if err != nil {
+		slog.Error("invalid log level", "err", err.Error())
+		return
+	}
+	slog.SetDefault(logger)
+
 	swg := sizedwaitgroup.New(threads)
 
 	for _, filepath := range files {
@@ -10,6 +10,11 @@ import (
 func init() {
 	rootCmd.AddCommand(extractCmd)
 	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(recoverCmd)
+	rootCmd.AddCommand(trainDictCmd)
+	rootCmd.AddCommand(recompressCmd)
+
+	rootCmd.PersistentFlags().String("log-level", "info", "Minimum level of the logs: debug, info, warn or error")
 
 	extractCmd.Flags().IntP("threads", "t", 1, "Number of threads to use for extraction")
 	extractCmd.Flags().StringP("output", "o", "output", "Output directory for extracted files")
@@ -20,6 +25,20 @@ func init() {
 
 	verifyCmd.Flags().IntP("threads", "t", runtime.NumCPU(), "Number of threads to use for verification")
 	verifyCmd.Flags().Bool("json", false, "Output results in JSON format")
+
+	recoverCmd.Flags().Bool("metadata", false, "Append a metadata record describing the recovery to every recovered file")
+
+	trainDictCmd.Flags().StringP("output", "o", "dictionary", "File the dictionary is written to")
+	trainDictCmd.Flags().Int("max-size", 112<<10, "Maximum size of the dictionary in bytes")
+	trainDictCmd.Flags().Int("max-samples", 10000, "Number of records sampled")
+	trainDictCmd.Flags().Int("max-sample-size", 32<<10, "Number of bytes of a record used for training")
+	trainDictCmd.Flags().StringSliceP("mime-type", "m", []string{}, "MIME type of the records to sample, a type ending with / matches its subtypes")
+	trainDictCmd.Flags().StringSlice("host", []string{}, "Host of the records to sample")
+
+	recompressCmd.Flags().StringP("compression", "c", "zstd", "Compression of the new files: gzip, zstd, xz or none")
+	recompressCmd.Flags().IntP("level", "l", 0, "Compression level, 0 uses the default level of the algorithm")
+	recompressCmd.Flags().StringP("dictionary", "d", "", "ZStd dictionary to compress with, embedded in the new files")
+	recompressCmd.Flags().StringP("output", "o", "", "Output directory of the new files, default is the directory of each file")
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -45,6 +64,30 @@ var verifyCmd = &cobra.Command{
 	Run:   verify,
 }
 
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Recover the .open WARC file(s) left by a crash",
+	Long:  `Truncate .open WARC files, or all the .open WARC files of directories, after their last complete record and remove their .open suffix`,
+	Args:  cobra.MinimumNArgs(1),
+	Run:   recoverFiles,
+}
+
+var trainDictCmd = &cobra.Command{
+	Use:   "train-dict",
+	Short: "Train a ZStd dictionary from one or many WARC file(s)",
+	Long:  `Sample records from WARC files, optionally filtered by MIME type or host, and train a ZStd dictionary to compress WARC files with`,
+	Args:  cobra.MinimumNArgs(1),
+	Run:   trainDict,
+}
+
+var recompressCmd = &cobra.Command{
+	Use:   "recompress",
+	Short: "Recompress one or many WARC file(s) with another compression",
+	Long:  `Rewrite WARC files with another compression, one compressed member per record, keeping the records byte for byte, except the WARC-Filename of warcinfo records, and verifying their block and payload digests`,
+	Args:  cobra.MinimumNArgs(1),
+	Run:   recompress,
+}
+
 func main() {
 	err := rootCmd.Execute()
 	if err != nil {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CorentinB/warc"
+	"github.com/spf13/cobra"
+)
+
+func recompress(cmd *cobra.Command, files []string) {
+	logger, err := newLogger(cmd)
+	if err != nil {
+		slog.Error("invalid log level", "err", err.Error())
+		return
+	}
+
+	var options warc.RecompressOptions
+
+	if options.Compression, err = cmd.Flags().GetString("compression"); err != nil {
+		logger.Error("invalid compression value", "err", err.Error())
+		return
+	}
+
+	compression, err := warc.ParseCompressionAlgorithm(options.Compression)
+	if err != nil {
+		logger.Error("invalid compression value", "err", err.Error())
+		return
+	}
+
+	if options.CompressionLevel, err = cmd.Flags().GetInt("level"); err != nil {
+		logger.Error("invalid level value", "err", err.Error())
+		return
+	}
+
+	dictionaryPath, err := cmd.Flags().GetString("dictionary")
+	if err != nil {
+		logger.Error("invalid dictionary value", "err", err.Error())
+		return
+	}
+
+	if dictionaryPath != "" {
+		options.Dictionary, err = os.ReadFile(dictionaryPath)
+		if err != nil {
+			logger.Error("unable to read the dictionary", "err", err.Error(), "file", dictionaryPath)
+			return
+		}
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		logger.Error("invalid output value", "err", err.Error())
+		return
+	}
+
+	var failed bool
+
+	for _, path := range files {
+		dst := recompressedPath(path, output, compression)
+		if dst == path {
+			logger.Error("the file already has the requested compression, use another output directory", "file", path)
+			failed = true
+			continue
+		}
+
+		records, err := warc.RecompressWARCFile(path, dst, options)
+		if err != nil {
+			logger.Error("unable to recompress file", "err", err.Error(), "file", path)
+			failed = true
+			continue
+		}
+
+		logger.Info("recompressed file", "file", path, "output", dst, "records", records)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// recompressedPath returns the path of the file path recompressed with compression,
+// in the output directory or, if it is empty, next to it.
+func recompressedPath(path, output string, compression warc.CompressionAlgorithm) string {
+	dir, name := filepath.Split(path)
+	if output != "" {
+		dir = output
+	}
+
+	for _, extension := range []string{".warc.gz", ".warc.zst", ".warc.xz", ".warc"} {
+		if strings.HasSuffix(name, extension) {
+			name = strings.TrimSuffix(name, extension)
+			break
+		}
+	}
+
+	return filepath.Join(dir, name+compression.Extension())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/CorentinB/warc"
+	"github.com/spf13/cobra"
+)
+
+func recoverFiles(cmd *cobra.Command, paths []string) {
+	logger, err := newLogger(cmd)
+	if err != nil {
+		slog.Error("invalid log level", "err", err.Error())
+		os.Exit(1)
+	}
+
+	appendMetadata, err := cmd.Flags().GetBool("metadata")
+	if err != nil {
+		logger.Error("invalid metadata value", "err", err.Error())
+		os.Exit(1)
+	}
+
+	var failed bool
+
+	for _, path := range paths {
+		var recovered []warc.RecoveredFile
+
+		if info, err := os.Stat(path); err != nil {
+			logger.Error("unable to stat path", "err", err.Error(), "path", path)
+			failed = true
+			continue
+		} else if info.IsDir() {
+			recovered, err = warc.RecoverWARCFiles(path, appendMetadata)
+			if err != nil {
+				logger.Error("unable to recover files", "err", err.Error(), "dir", path)
+				failed = true
+			}
+		} else if strings.HasSuffix(path, ".open") {
+			file, err := warc.RecoverWARCFile(path, appendMetadata)
+			if err != nil {
+				logger.Error("unable to recover file", "err", err.Error(), "file", path)
+				failed = true
+				continue
+			}
+			recovered = append(recovered, file)
+		} else {
+			logger.Error("not an .open WARC file", "file", path)
+			failed = true
+			continue
+		}
+
+		for _, file := range recovered {
+			if file.Removed {
+				logger.Info("removed file without any complete record", "file", file.OpenPath)
+			} else {
+				logger.Info("recovered file", "file", file.Path, "records", file.Records, "truncatedBytes", file.TruncatedBytes)
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
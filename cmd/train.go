@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/CorentinB/warc"
+	"github.com/spf13/cobra"
+)
+
+func trainDict(cmd *cobra.Command, files []string) {
+	logger, err := newLogger(cmd)
+	if err != nil {
+		slog.Error("invalid log level", "err", err.Error())
+		return
+	}
+
+	var options warc.DictionaryOptions
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		logger.Error("invalid output value", "err", err.Error())
+		return
+	}
+
+	if options.MaxSize, err = cmd.Flags().GetInt("max-size"); err != nil {
+		logger.Error("invalid max-size value", "err", err.Error())
+		return
+	}
+
+	if options.MaxSamples, err = cmd.Flags().GetInt("max-samples"); err != nil {
+		logger.Error("invalid max-samples value", "err", err.Error())
+		return
+	}
+
+	if options.MaxSampleSize, err = cmd.Flags().GetInt("max-sample-size"); err != nil {
+		logger.Error("invalid max-sample-size value", "err", err.Error())
+		return
+	}
+
+	if options.MIMETypes, err = cmd.Flags().GetStringSlice("mime-type"); err != nil {
+		logger.Error("invalid mime-type value", "err", err.Error())
+		return
+	}
+
+	if options.Hosts, err = cmd.Flags().GetStringSlice("host"); err != nil {
+		logger.Error("invalid host value", "err", err.Error())
+		return
+	}
+
+	dictionary, err := warc.TrainZStdDictionary(files, options)
+	if err != nil {
+		logger.Error("unable to train the dictionary", "err", err.Error())
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(output, dictionary, 0644); err != nil {
+		logger.Error("unable to write the dictionary", "err", err.Error(), "file", output)
+		os.Exit(1)
+	}
+
+	logger.Info("trained dictionary", "file", output, "size", len(dictionary))
+}
@@ -3,9 +3,29 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"os"
 	"time"
+
+	"github.com/spf13/cobra"
 )
 
+// newLogger returns the logger of cmd, writing to stdout at the level of the
+// --log-level flag, in JSON if the command has a --json flag that is set.
+func newLogger(cmd *cobra.Command) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cmd.Flags().Lookup("log-level").Value.String())); err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	if json := cmd.Flags().Lookup("json"); json != nil && json.Changed {
+		return slog.New(slog.NewJSONHandler(os.Stdout, options)), nil
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stdout, options)), nil
+}
+
 func printExtractReport(filePath string, results map[string]int, elapsed time.Duration) {
 	total := 0
 
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -15,13 +14,11 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var logger *slog.Logger
-
-func processVerifyRecord(record *warc.Record, filepath string, results chan<- result) {
+func processVerifyRecord(logger *slog.Logger, record *warc.Record, filepath string, results chan<- result) {
 	var res result
-	res.blockDigestErrorsCount, res.blockDigestValid = verifyBlockDigest(record, filepath)
-	res.payloadDigestErrorsCount, res.payloadDigestValid = verifyPayloadDigest(record, filepath)
-	res.warcVersionValid = verifyWarcVersion(record, filepath)
+	res.blockDigestErrorsCount, res.blockDigestValid = verifyBlockDigest(logger, record, filepath)
+	res.payloadDigestErrorsCount, res.payloadDigestValid = verifyPayloadDigest(logger, record, filepath)
+	res.warcVersionValid = verifyWarcVersion(logger, record, filepath)
 	results <- res
 }
 
@@ -40,10 +37,10 @@ func verify(cmd *cobra.Command, files []string) {
 		return
 	}
 
-	if cmd.Flags().Lookup("json").Changed {
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	} else {
-		logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger, err := newLogger(cmd)
+	if err != nil {
+		slog.Error("invalid log level", "err", err.Error())
+		return
 	}
 
 	for _, filepath := range files {
@@ -68,7 +65,7 @@ func verify(cmd *cobra.Command, files []string) {
 			go func() {
 				defer processWg.Done()
 				for record := range recordChan {
-					processVerifyRecord(record, filepath, results)
+					processVerifyRecord(logger, record, filepath, results)
 					record.Content.Close()
 				}
 			}()
@@ -164,7 +161,7 @@ func verify(cmd *cobra.Command, files []string) {
 	}
 }
 
-func verifyPayloadDigest(record *warc.Record, filepath string) (errorsCount int, valid bool) {
+func verifyPayloadDigest(logger *slog.Logger, record *warc.Record, filepath string) (errorsCount int, valid bool) {
 	valid = true
 
 	// Verify that the Payload-Digest field exists
@@ -192,57 +189,31 @@ func verifyPayloadDigest(record *warc.Record, filepath string) (errorsCount int,
 		return errorsCount, valid
 	}
 
-	resp, err := http.ReadResponse(bufio.NewReader(record.Content), nil)
-	if err != nil {
-		logger.Error("failed to read response", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"), "err", err.Error())
+	defer record.Content.Seek(0, 0)
+
+	if payloadDigestSplitted[0] != "sha1" && payloadDigestSplitted[0] != "sha256" {
+		logger.Error("WARC-Payload-Digest is not SHA1 or SHA256", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"))
 		valid = false
 		errorsCount++
 		return errorsCount, valid
 	}
-	defer resp.Body.Close()
-	defer record.Content.Seek(0, 0)
-
-	if resp.Header.Get("X-Crawler-Transfer-Encoding") != "" || resp.Header.Get("X-Crawler-Content-Encoding") != "" {
-		// This header being present in the HTTP headers indicates transfer-encoding and/or content-encoding were incorrectly stripped, causing us to not be able to verify the payload digest.
-		logger.Error("malfomed headers prevent accurate payload digest calculation", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"))
 
+	// The payload digest is computed the same way the writer does, see warc.GetPayloadDigest
+	payloadDigest, err := warc.GetPayloadDigest(record.Content, payloadDigestSplitted[0])
+	if errors.Is(err, warc.ErrPayloadDigestUnverifiable) {
+		logger.Error("malformed headers prevent accurate payload digest calculation", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"))
+		valid = false
+		errorsCount++
+		return errorsCount, valid
+	} else if err != nil {
+		logger.Error("failed to calculate payload digest", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"), "err", err.Error())
 		valid = false
 		errorsCount++
 		return errorsCount, valid
 	}
 
-	if payloadDigestSplitted[0] == "sha1" {
-		payloadDigest := warc.GetSHA1(resp.Body)
-		if payloadDigest == "ERROR" {
-			logger.Error("failed to calculate payload digest", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"))
-			valid = false
-			errorsCount++
-			return errorsCount, valid
-		}
-
-		if payloadDigest != payloadDigestSplitted[1] {
-			logger.Error("payload digests do not match", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"), "expected", payloadDigestSplitted[1], "got", payloadDigest)
-			valid = false
-			errorsCount++
-			return errorsCount, valid
-		}
-	} else if payloadDigestSplitted[0] == "sha256" {
-		payloadDigest := warc.GetSHA256Base16(resp.Body)
-		if payloadDigest == "ERROR" {
-			logger.Error("failed to calculate payload digest", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"))
-			valid = false
-			errorsCount++
-			return errorsCount, valid
-		}
-
-		if payloadDigest != payloadDigestSplitted[1] {
-			logger.Error("payload digests do not match", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"), "expected", payloadDigestSplitted[1], "got", payloadDigest)
-			valid = false
-			errorsCount++
-			return errorsCount, valid
-		}
-	} else {
-		logger.Error("WARC-Payload-Digest is not SHA1 or SHA256", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"))
+	if !strings.EqualFold(payloadDigest, record.Header.Get("WARC-Payload-Digest")) {
+		logger.Error("payload digests do not match", "file", filepath, "recordID", record.Header.Get("WARC-Record-ID"), "expected", payloadDigestSplitted[1], "got", strings.TrimPrefix(payloadDigest, payloadDigestSplitted[0]+":"))
 		valid = false
 		errorsCount++
 		return errorsCount, valid
@@ -251,7 +222,7 @@ func verifyPayloadDigest(record *warc.Record, filepath string) (errorsCount int,
 	return errorsCount, valid
 }
 
-func verifyBlockDigest(record *warc.Record, filepath string) (errorsCount int, valid bool) {
+func verifyBlockDigest(logger *slog.Logger, record *warc.Record, filepath string) (errorsCount int, valid bool) {
 	valid = true
 
 	// Verify that the WARC-Block-Digest is sha1
@@ -296,7 +267,7 @@ func verifyBlockDigest(record *warc.Record, filepath string) (errorsCount int, v
 	return errorsCount, valid
 }
 
-func verifyWarcVersion(record *warc.Record, filepath string) (valid bool) {
+func verifyWarcVersion(logger *slog.Logger, record *warc.Record, filepath string) (valid bool) {
 	valid = true
 	if record.Version != "WARC/1.0" && record.Version != "WARC/1.1" {
 		logger.Error("invalid WARC version", "file", filepath, "recordID", record.Header.Get("WARC-Record)"))
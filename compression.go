@@ -0,0 +1,54 @@
+package warc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CompressionAlgorithm is the algorithm compressing the records of a WARC file,
+// every record being compressed on its own (a GZIP member, a ZStd frame or an XZ
+// stream) so that it can be read without decompressing the records before it.
+type CompressionAlgorithm string
+
+const (
+	// CompressionNone writes uncompressed .warc files
+	CompressionNone CompressionAlgorithm = ""
+	// CompressionGZIP writes .warc.gz files, levels go from gzip.HuffmanOnly (-2) to
+	// gzip.BestCompression (9)
+	CompressionGZIP CompressionAlgorithm = "GZIP"
+	// CompressionZSTD writes .warc.zst files, levels are those of the zstd command (1 to 22)
+	CompressionZSTD CompressionAlgorithm = "ZSTD"
+	// CompressionXZ writes .warc.xz files, it has no levels
+	CompressionXZ CompressionAlgorithm = "XZ"
+)
+
+// ParseCompressionAlgorithm returns the algorithm named name, ignoring case.
+// Common aliases like "gz", "zst" and "none" are accepted.
+func ParseCompressionAlgorithm(name string) (CompressionAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip", "gz":
+		return CompressionGZIP, nil
+	case "zstd", "zst":
+		return CompressionZSTD, nil
+	case "xz":
+		return CompressionXZ, nil
+	default:
+		return "", fmt.Errorf("invalid compression algorithm: %s", name)
+	}
+}
+
+// Extension returns the extension of the WARC files compressed with a, e.g. ".warc.gz".
+func (a CompressionAlgorithm) Extension() string {
+	switch a {
+	case CompressionGZIP:
+		return ".warc.gz"
+	case CompressionZSTD:
+		return ".warc.zst"
+	case CompressionXZ:
+		return ".warc.xz"
+	default:
+		return ".warc"
+	}
+}
@@ -0,0 +1,85 @@
+package warc
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseCompressionAlgorithm(t *testing.T) {
+	for name, expected := range map[string]CompressionAlgorithm{
+		"":     CompressionNone,
+		"none": CompressionNone,
+		"gzip": CompressionGZIP,
+		"GZIP": CompressionGZIP,
+		"gz":   CompressionGZIP,
+		"Zstd": CompressionZSTD,
+		"zst":  CompressionZSTD,
+		"XZ":   CompressionXZ,
+	} {
+		algorithm, err := ParseCompressionAlgorithm(name)
+		if err != nil || algorithm != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", name, expected, algorithm, err)
+		}
+	}
+
+	if _, err := ParseCompressionAlgorithm("brotli"); err == nil {
+		t.Error("expected an error with an unknown algorithm")
+	}
+}
+
+func TestRotatorCompression(t *testing.T) {
+	for _, test := range []struct {
+		compression string
+		level       int
+		extension   string
+	}{
+		{"gzip", 9, ".warc.gz"},
+		{"gzip", 1, ".warc.gz"},
+		{"zstd", 19, ".warc.zst"},
+		{"ZST", 1, ".warc.zst"},
+		{"xz", 0, ".warc.xz"},
+		{"none", 0, ".warc"},
+	} {
+		rotatorSettings := defaultRotatorSettings(t)
+		rotatorSettings.Compression = test.compression
+		rotatorSettings.CompressionLevel = test.level
+
+		records, doneChannels, err := rotatorSettings.NewWARCRotator()
+		if err != nil {
+			t.Fatalf("%s level %d: %v", test.compression, test.level, err)
+		}
+
+		writeTestBatches(t, records, 2, 2, 1000)
+		closeTestRotator(records, doneChannels)
+
+		// The files are read back through NewDecompressionReader
+		if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); !slices.Equal(counts, []int{4}) {
+			t.Errorf("%s level %d: expected 1 file of 4 records, got %v", test.compression, test.level, counts)
+		}
+
+		if paths, _ := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*"+test.extension)); len(paths) != 1 {
+			t.Errorf("%s level %d: expected 1 %s file, got %v", test.compression, test.level, test.extension, paths)
+		}
+	}
+}
+
+func TestRotatorCompressionLevelSettings(t *testing.T) {
+	for _, test := range []struct {
+		compression string
+		level       int
+	}{
+		{"GZIP", 42},
+		{"ZSTD", 23},
+		{"XZ", 6},
+		{"brotli", 0},
+	} {
+		rotatorSettings := defaultRotatorSettings(t)
+		rotatorSettings.Compression = test.compression
+		rotatorSettings.CompressionLevel = test.level
+
+		if _, _, err := rotatorSettings.NewWARCRotator(); err == nil {
+			t.Errorf("%s level %d: expected an error", test.compression, test.level)
+		}
+	}
+}
@@ -109,10 +109,27 @@ func decompressZStd(br *bufio.Reader) (io.ReadCloser, error) {
 // decompressZStdCustomDict decompresses a ZStd stream with a prefixed custom dictionary from the given input
 // reader r.
 func decompressZStdCustomDict(br *bufio.Reader) (io.ReadCloser, error) {
+	dict, err := readZStdCustomDict(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open ZStd reader, with the given dictionary
+	dr, err := zstd.NewReader(br, zstd.WithDecoderDicts(dict), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("create ZStd reader: %w", err)
+	}
+
+	return dr.IOReadCloser(), nil
+}
+
+// readZStdCustomDict reads the skippable frame holding the ZStd compressed custom dictionary
+// at the beginning of r, and returns the decompressed dictionary.
+func readZStdCustomDict(r io.Reader) ([]byte, error) {
 	// Read header
 	var header [8]byte
 
-	_, err := br.Read(header[:])
+	_, err := io.ReadFull(r, header[:])
 	if err != nil {
 		return nil, fmt.Errorf("read ZStd skippable frame header: %w", err)
 	}
@@ -123,7 +140,7 @@ func decompressZStdCustomDict(br *bufio.Reader) (io.ReadCloser, error) {
 	}
 
 	// Read ZStd compressed custom dictionary
-	lr := io.LimitReader(br, int64(length))
+	lr := io.LimitReader(r, int64(length))
 
 	dictr, err := zstd.NewReader(lr)
 	if err != nil {
@@ -143,11 +160,5 @@ func decompressZStdCustomDict(br *bufio.Reader) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("discard remaining bytes of ZStd compressed custom dictionary: %w", err)
 	}
 
-	// Open ZStd reader, with the given dictionary
-	dr, err := zstd.NewReader(br, zstd.WithDecoderDicts(dict), zstd.WithDecoderConcurrency(1))
-	if err != nil {
-		return nil, fmt.Errorf("create ZStd reader: %w", err)
-	}
-
-	return dr.IOReadCloser(), nil
+	return dict, nil
 }
@@ -1,6 +1,7 @@
 package warc
 
 import (
+	"bufio"
 	"io"
 	"net"
 	"net/http"
@@ -10,14 +11,17 @@ import (
 	"time"
 )
 
-var CDXHTTPClient = http.Client{
-	Timeout: 10 * time.Second,
-	Transport: &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout: 5 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: 5 * time.Second,
-	},
+// newCDXHTTPClient returns the HTTP client used by a client to query its CDX server.
+func newCDXHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 5 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
 }
 
 type DedupeOptions struct {
@@ -26,13 +30,24 @@ type DedupeOptions struct {
 	SizeThreshold int
 	LocalDedupe   bool
 	CDXDedupe     bool
+	// ConditionalRequests makes the client send If-None-Match and If-Modified-Since
+	// headers, built from the ETag and Last-Modified headers of the prior capture of
+	// the same URL. A 304 response to such a request is written as a revisit record
+	// with the server-not-modified profile, referring to the prior capture.
+	ConditionalRequests bool
 }
 
 type revisitRecord struct {
 	responseUUID string
 	targetURI    string
 	date         string
+	etag         string
+	lastModified string
 	size         int
+	// digest and contentLength are the payload digest, without its algorithm,
+	// and the Content-Length of the response of a validated capture
+	digest        string
+	contentLength int64
 }
 
 func (d *customDialer) checkLocalRevisit(digest string) revisitRecord {
@@ -44,7 +59,135 @@ func (d *customDialer) checkLocalRevisit(digest string) revisitRecord {
 	return revisitRecord{}
 }
 
-func checkCDXRevisit(CDXURL string, digest string, targetURI string, cookie string) (revisitRecord, error) {
+// checkConditionalRevisit returns the prior capture of targetURI, if any.
+func (d *customDialer) checkConditionalRevisit(targetURI string) revisitRecord {
+	revisit, exists := d.client.conditionalHashTable.Load(targetURI)
+	if exists {
+		return revisit.(revisitRecord)
+	}
+
+	return revisitRecord{}
+}
+
+// readCapturedResponse parses the HTTP response of a response record, leaving
+// its content at the start.
+func readCapturedResponse(record *Record) (*http.Response, error) {
+	if _, err := record.Content.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	resp, err := ReadHTTPResponse(bufio.NewReader(record.Content))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if _, err := record.Content.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// storeConditionalCapture remembers the validators of a successful response record,
+// so that the next request for the same URL can be made conditional.
+func (d *customDialer) storeConditionalCapture(record *Record, responseUUID, targetURI, date string) error {
+	resp, err := readCapturedResponse(record)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return nil
+	}
+
+	d.client.conditionalHashTable.Store(targetURI, revisitRecord{
+		responseUUID: responseUUID,
+		targetURI:    targetURI,
+		date:         date,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	})
+
+	return nil
+}
+
+// storeValidatedCapture remembers the payload digest of a successful response
+// record with a strong ETag and a Content-Length, so that the next response of
+// the same URL with the same validators is expected to be a duplicate, see
+// checkValidatedCapture.
+func (d *customDialer) storeValidatedCapture(record *Record, digest, targetURI string) error {
+	resp, err := readCapturedResponse(record)
+	if err != nil {
+		return err
+	}
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || strings.HasPrefix(etag, "W/") || resp.ContentLength <= 0 {
+		return nil
+	}
+
+	d.client.validatedHashTable.Store(targetURI, revisitRecord{
+		targetURI:     targetURI,
+		etag:          etag,
+		digest:        digest,
+		contentLength: resp.ContentLength,
+	})
+
+	return nil
+}
+
+// checkValidatedCapture returns the prior capture of targetURI which has the
+// same strong ETag and Content-Length as resp, if any. The payload of resp is
+// then expected to have the digest of the capture, so it is hashed without
+// being spooled, and the digest is checked once computed.
+func (d *customDialer) checkValidatedCapture(targetURI string, resp *http.Response) revisitRecord {
+	capture, exists := d.client.validatedHashTable.Load(targetURI)
+	if !exists || resp.StatusCode != http.StatusOK {
+		return revisitRecord{}
+	}
+
+	if capture.(revisitRecord).etag != resp.Header.Get("ETag") || capture.(revisitRecord).contentLength != resp.ContentLength {
+		return revisitRecord{}
+	}
+
+	return capture.(revisitRecord)
+}
+
+// setConditionalHeaders adds the If-None-Match and If-Modified-Since headers to req
+// if its URL has already been captured, unless the caller already set them.
+func (c *CustomHTTPClient) setConditionalHeaders(req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return
+	}
+
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+
+	// Captures are stored under their canonical WARC-Target-URI
+	targetURI, err := canonicalRequestURL(req)
+	if err != nil {
+		return
+	}
+
+	capture, exists := c.conditionalHashTable.Load(targetURI)
+	if !exists {
+		return
+	}
+
+	if etag := capture.(revisitRecord).etag; etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	if lastModified := capture.(revisitRecord).lastModified; lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	c.logger.Debug("sending conditional request", "targetURI", targetURI, "ifNoneMatch", req.Header.Get("If-None-Match"), "ifModifiedSince", req.Header.Get("If-Modified-Since"))
+}
+
+func (c *CustomHTTPClient) checkCDXRevisit(CDXURL string, digest string, targetURI string, cookie string) (revisitRecord, error) {
 	req, err := http.NewRequest("GET", CDXURL+"/web/timemap/cdx?url="+url.QueryEscape(targetURI)+"&limit=-1", nil)
 	if err != nil {
 		return revisitRecord{}, err
@@ -53,7 +196,7 @@ func checkCDXRevisit(CDXURL string, digest string, targetURI string, cookie stri
 	if cookie != "" {
 		req.Header.Add("Cookie", cookie)
 	}
-	resp, err := CDXHTTPClient.Do(req)
+	resp, err := c.cdxHTTPClient.Do(req)
 	if err != nil {
 		return revisitRecord{}, err
 	}
@@ -89,16 +89,30 @@ type customConnection struct {
 	net.Conn
 	io.Reader
 	io.Writer
-	closers []io.Closer
+	reqWriter  *io.PipeWriter
+	respWriter *io.PipeWriter
+	closers    []io.Closer
 	sync.WaitGroup
 }
 
+// Read and Write forward connection errors to the pipes, so that
+// the capture knows the exchange was interrupted and why.
 func (cc *customConnection) Read(b []byte) (int, error) {
-	return cc.Reader.Read(b)
+	n, err := cc.Reader.Read(b)
+	if err != nil && err != io.EOF {
+		cc.respWriter.CloseWithError(err)
+	}
+
+	return n, err
 }
 
 func (cc *customConnection) Write(b []byte) (int, error) {
-	return cc.Writer.Write(b)
+	n, err := cc.Writer.Write(b)
+	if err != nil {
+		cc.reqWriter.CloseWithError(err)
+	}
+
+	return n, err
 }
 
 func (cc *customConnection) Close() error {
@@ -120,10 +134,12 @@ func (d *customDialer) wrapConnection(ctx context.Context, c net.Conn, scheme st
 	go d.writeWARCFromConnection(ctx, reqReader, respReader, scheme, c)
 
 	return &customConnection{
-		Conn:    c,
-		closers: []io.Closer{reqWriter, respWriter},
-		Reader:  io.TeeReader(c, respWriter),
-		Writer:  io.MultiWriter(reqWriter, c),
+		Conn:       c,
+		reqWriter:  reqWriter,
+		respWriter: respWriter,
+		closers:    []io.Closer{reqWriter, respWriter},
+		Reader:     io.TeeReader(c, respWriter),
+		Writer:     io.MultiWriter(reqWriter, c),
 	}
 }
 
@@ -136,14 +152,15 @@ func (d *customDialer) CustomDialContext(ctx context.Context, network, address s
 
 	IP, _, err := d.archiveDNS(ctx, address)
 	if err != nil {
-		return nil, err
+		return nil, &dnsResolutionError{err: err}
 	}
 
+	dialStart := time.Now()
 	if d.proxyDialer != nil {
 		conn, err = d.proxyDialer.DialContext(ctx, network, address)
 	} else {
 		if d.client.randomLocalIP {
-			localAddr := getLocalAddr(network, IP)
+			localAddr := d.client.getLocalAddr(network, IP)
 			if localAddr != nil {
 				if network == "tcp" || network == "tcp4" || network == "tcp6" {
 					d.LocalAddr = localAddr.(*net.TCPAddr)
@@ -157,9 +174,13 @@ func (d *customDialer) CustomDialContext(ctx context.Context, network, address s
 	}
 
 	if err != nil {
+		d.client.logger.Debug("dial failed", "network", network, "address", address, "ip", IP, "err", err)
 		return nil, err
 	}
 
+	d.client.metrics.ObserveDialDuration(time.Since(dialStart))
+	d.client.logger.Debug("dialed", "network", network, "address", address, "ip", IP, "duration", time.Since(dialStart))
+
 	return d.wrapConnection(ctx, conn, "http"), nil
 }
 
@@ -176,16 +197,19 @@ func (d *customDialer) CustomDialTLSContext(ctx context.Context, network, addres
 
 	IP, _, err := d.archiveDNS(ctx, address)
 	if err != nil {
-		return nil, err
+		return nil, &dnsResolutionError{err: err}
 	}
 
-	var plainConn net.Conn
+	var (
+		plainConn net.Conn
+		dialStart = time.Now()
+	)
 
 	if d.proxyDialer != nil {
 		plainConn, err = d.proxyDialer.DialContext(ctx, network, address)
 	} else {
 		if d.client.randomLocalIP {
-			localAddr := getLocalAddr(network, IP)
+			localAddr := d.client.getLocalAddr(network, IP)
 			if localAddr != nil {
 				if network == "tcp" || network == "tcp4" || network == "tcp6" {
 					d.LocalAddr = localAddr.(*net.TCPAddr)
@@ -199,9 +223,13 @@ func (d *customDialer) CustomDialTLSContext(ctx context.Context, network, addres
 	}
 
 	if err != nil {
+		d.client.logger.Debug("dial failed", "network", network, "address", address, "ip", IP, "err", err)
 		return nil, err
 	}
 
+	d.client.metrics.ObserveDialDuration(time.Since(dialStart))
+	d.client.logger.Debug("dialed", "network", network, "address", address, "ip", IP, "duration", time.Since(dialStart))
+
 	cfg := new(tls.Config)
 	serverName := address[:strings.LastIndex(address, ":")]
 	cfg.ServerName = serverName
@@ -213,6 +241,7 @@ func (d *customDialer) CustomDialTLSContext(ctx context.Context, network, addres
 		return nil, err
 	}
 
+	handshakeStart := time.Now()
 	errc := make(chan error, 2)
 	timer := time.AfterFunc(d.client.TLSHandshakeTimeout, func() {
 		errc <- errors.New("TLS handshake timeout")
@@ -224,14 +253,19 @@ func (d *customDialer) CustomDialTLSContext(ctx context.Context, network, addres
 		errc <- err
 	}()
 	if err := <-errc; err != nil {
+		d.client.logger.Debug("TLS handshake failed", "address", address, "serverName", serverName, "err", err)
+
 		closeErr := plainConn.Close()
 		if closeErr != nil {
-			return nil, fmt.Errorf("CustomDialTLS: TLS handshake failed and closing plain connection failed: %s", closeErr.Error())
+			return nil, &tlsHandshakeError{err: fmt.Errorf("CustomDialTLS: TLS handshake failed and closing plain connection failed: %s", closeErr.Error())}
 		}
 
-		return nil, err
+		return nil, &tlsHandshakeError{err: err}
 	}
 
+	d.client.metrics.ObserveTLSHandshakeDuration(time.Since(handshakeStart))
+	d.client.logger.Debug("TLS handshake completed", "address", address, "serverName", serverName, "duration", time.Since(handshakeStart))
+
 	return d.wrapConnection(ctx, tlsConn, "https"), nil
 }
 
@@ -281,42 +315,134 @@ func (d *customDialer) writeWARCFromConnection(ctx context.Context, reqPipe, res
 	}
 
 	var (
+		start      = time.Now()
 		batch      = NewRecordBatch(feedbackChan)
 		recordChan = make(chan *Record, 2)
-		recordIDs  []string
-		err        = new(Error)
+		requestID  = uuid.NewString()
+		responseID = uuid.NewString()
 		errs       = errgroup.Group{}
+		// warcTargetURI is known once both readers returned
+		warcTargetURI string
 		// Channels for passing the WARC-Target-URI between the request and response readers
 		// These channels are used in a way so that both readers can synhronize themselves
-		targetURIReqCh  = make(chan string, 1) // readRequest() -> readResponse() : readRequest() sends the WARC-Target-URI then closes the channel or closes without sending anything if an error occurs, readResponse() reads the WARC-Target-URI
+		targetURIReqCh  = make(chan string, 1) // readRequest() -> readResponse() : readRequest() sends the WARC-Target-URI once the request headers are parsed then closes the channel, or closes without sending anything if an error occurs before, readResponse() reads the WARC-Target-URI
 		targetURIRespCh = make(chan string, 1) // readResponse() -> writeWARCFromConnection() : readResponse() sends the WARC-Target-URI then closes the channel or closes without sending anything if an error occurs, writeWARCFromConnection() reads the WARC-Target-URI
+		// Channels for handing over the rest of the connection once it has been upgraded to WebSocket
+		// readRequest() sends its reader before sending the WARC-Target-URI, so that readResponse() knows if the upgrade was asked for
+		webSocketReqCh  = make(chan *bufio.Reader, 1)
+		webSocketRespCh = make(chan *bufio.Reader, 1)
+		webSocketOpened = false
 	)
 
+	emit := func(kind EventKind, err error) {
+		d.client.emitError(&Event{
+			Kind:      kind,
+			Err:       err,
+			Func:      "writeWARCFromConnection",
+			TargetURI: warcTargetURI,
+			Elapsed:   time.Since(start),
+		})
+	}
+
+	// The connection must always be consumed, else it would block, so if the WebSocket
+	// frames aren't captured, we drain what is left of the connection
+	defer func() {
+		if webSocketOpened {
+			return
+		}
+
+		for _, webSocketCh := range []chan *bufio.Reader{webSocketReqCh, webSocketRespCh} {
+			select {
+			case r := <-webSocketCh:
+				io.Copy(io.Discard, r)
+			default:
+			}
+		}
+	}()
+
 	// Run request and response readers in parallel, respecting context
 	errs.Go(func() error {
-		return d.readRequest(ctx, scheme, reqPipe, targetURIReqCh, recordChan)
+		return d.readRequest(ctx, scheme, reqPipe, targetURIReqCh, recordChan, webSocketReqCh)
 	})
 
 	errs.Go(func() error {
-		return d.readResponse(ctx, respPipe, targetURIReqCh, targetURIRespCh, recordChan)
+		return d.readResponse(ctx, respPipe, targetURIReqCh, targetURIRespCh, recordChan, webSocketReqCh, webSocketRespCh)
 	})
 
 	// Wait for both goroutines to finish
 	readErr := errs.Wait()
 	close(recordChan)
 
+	var IP string
+	if d.proxyDialer == nil {
+		switch addr := conn.RemoteAddr().(type) {
+		case *net.TCPAddr:
+			IP = addr.IP.String()
+		}
+	}
+
 	if readErr != nil {
-		d.client.ErrChan <- &Error{
-			Err:  readErr,
-			Func: "writeWARCFromConnection",
+		// Both readers returned, so the WARC-Target-URI is either waiting in one of the channels or was never found
+		for _, targetURICh := range []chan string{targetURIRespCh, targetURIReqCh} {
+			select {
+			case recv, ok := <-targetURICh:
+				if ok {
+					warcTargetURI = recv
+				}
+			default:
+			}
+		}
+
+		emit("", readErr)
+
+		var discardErr *DiscardHookError
+		if d.client.archiveFailures && !errors.As(readErr, &discardErr) {
+			if warcTargetURI == "" {
+				warcTargetURI = scheme + "://" + conn.RemoteAddr().String()
+			}
+
+			failureRecord := d.client.newFailureRecord(warcTargetURI, readErr)
+
+			for record := range recordChan {
+				if record.Header.Get("WARC-Truncated") != "" {
+					record.Header.Set("WARC-Truncated", truncationReason(readErr))
+				}
+
+				if IP != "" {
+					record.Header.Set("WARC-IP-Address", IP)
+				}
+
+				if record.Header.Get("WARC-Type") == "request" {
+					record.Header.Set("WARC-Record-ID", "<urn:uuid:"+requestID+">")
+					record.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+responseID+">")
+				} else {
+					record.Header.Set("WARC-Record-ID", "<urn:uuid:"+responseID+">")
+					record.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+requestID+">")
+				}
+
+				record.Header.Set("WARC-Target-URI", warcTargetURI)
+				batch.Records = append(batch.Records, record)
+
+				// The metadata record describing the error is concurrent to the partial exchange, preferably its request
+				if failureRecord.Header.Get("WARC-Concurrent-To") == "" || record.Header.Get("WARC-Type") == "request" {
+					failureRecord.Header.Set("WARC-Concurrent-To", record.Header.Get("WARC-Record-ID"))
+				}
+			}
+
+			batch.Records = append(batch.Records, failureRecord)
+
+			d.client.logger.Debug("archiving failed exchange", "targetURI", warcTargetURI, "records", len(batch.Records))
+
+			// The writer must get the batch even if the context was cancelled, else the failure would be lost
+			d.client.rotatorSettings.SendBatch(context.Background(), batch, false)
+			batchSent = true
+
+			return
 		}
 
 		for record := range recordChan {
 			if closeErr := record.Content.Close(); closeErr != nil {
-				d.client.ErrChan <- &Error{
-					Err:  closeErr,
-					Func: "writeWARCFromConnection",
-				}
+				emit(EventKindWrite, closeErr)
 			}
 		}
 
@@ -328,21 +454,16 @@ func (d *customDialer) writeWARCFromConnection(ctx context.Context, reqPipe, res
 		case <-ctx.Done():
 			return
 		default:
-			recordIDs = append(recordIDs, uuid.NewString())
 			batch.Records = append(batch.Records, record)
 		}
 	}
 
 	if len(batch.Records) != 2 {
-		err.Err = errors.New("warc: there was an unspecified problem creating one of the WARC records")
-		d.client.ErrChan <- err
+		emit(EventKindWrite, errors.New("warc: there was an unspecified problem creating one of the WARC records"))
 
 		for _, record := range batch.Records {
 			if closeErr := record.Content.Close(); closeErr != nil {
-				d.client.ErrChan <- &Error{
-					Err:  closeErr,
-					Func: "writeWARCFromConnection",
-				}
+				emit(EventKindWrite, closeErr)
 			}
 		}
 
@@ -353,7 +474,6 @@ func (d *customDialer) writeWARCFromConnection(ctx context.Context, reqPipe, res
 		slices.Reverse(batch.Records)
 	}
 
-	var warcTargetURI string
 	select {
 	case recv, ok := <-targetURIRespCh:
 		if !ok {
@@ -364,34 +484,27 @@ func (d *customDialer) writeWARCFromConnection(ctx context.Context, reqPipe, res
 		return
 	}
 
-	for i, r := range batch.Records {
+	for _, r := range batch.Records {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			if d.proxyDialer == nil {
-				switch addr := conn.RemoteAddr().(type) {
-				case *net.TCPAddr:
-					IP := addr.IP.String()
-					r.Header.Set("WARC-IP-Address", IP)
-				}
+			if IP != "" {
+				r.Header.Set("WARC-IP-Address", IP)
 			}
 
-			r.Header.Set("WARC-Record-ID", "<urn:uuid:"+recordIDs[i]+">")
-
-			if i == len(recordIDs)-1 {
-				r.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+recordIDs[0]+">")
+			if r.Header.Get("WARC-Type") == "request" {
+				r.Header.Set("WARC-Record-ID", "<urn:uuid:"+requestID+">")
+				r.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+responseID+">")
 			} else {
-				r.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+recordIDs[1]+">")
+				r.Header.Set("WARC-Record-ID", "<urn:uuid:"+responseID+">")
+				r.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+requestID+">")
 			}
 
 			r.Header.Set("WARC-Target-URI", warcTargetURI)
 
 			if _, seekErr := r.Content.Seek(0, 0); seekErr != nil {
-				d.client.ErrChan <- &Error{
-					Err:  seekErr,
-					Func: "writeWARCFromConnection",
-				}
+				emit(EventKindWrite, seekErr)
 				return
 			}
 
@@ -401,25 +514,61 @@ func (d *customDialer) writeWARCFromConnection(ctx context.Context, reqPipe, res
 			if d.client.dedupeOptions.LocalDedupe {
 				if r.Header.Get("WARC-Type") == "response" && r.Header.Get("WARC-Payload-Digest")[5:] != "3I42H3S6NNFQ2MSVX7XZKYAYSCX5QBYJ" {
 					d.client.dedupeHashTable.Store(r.Header.Get("WARC-Payload-Digest")[5:], revisitRecord{
-						responseUUID: recordIDs[i],
+						responseUUID: responseID,
 						size:         getContentLength(r.Content),
 						targetURI:    warcTargetURI,
 						date:         batch.CaptureTime,
 					})
+
+					if storeErr := d.storeValidatedCapture(r, r.Header.Get("WARC-Payload-Digest")[5:], warcTargetURI); storeErr != nil {
+						emit(EventKindDedupe, storeErr)
+					}
+				}
+			}
+
+			if d.client.dedupeOptions.ConditionalRequests && r.Header.Get("WARC-Type") == "response" {
+				if storeErr := d.storeConditionalCapture(r, responseID, warcTargetURI, batch.CaptureTime); storeErr != nil {
+					emit(EventKindDedupe, storeErr)
 				}
 			}
 		}
 	}
 
-	select {
-	case d.client.WARCWriter <- batch:
-		batchSent = true
-	case <-ctx.Done():
+	// The batch belongs to the rotator once sent, what the log needs is read before
+	responseType := batch.Records[0].Header.Get("WARC-Type")
+
+	if err := d.client.rotatorSettings.SendBatch(ctx, batch, false); err != nil {
 		return
 	}
+	batchSent = true
+
+	d.client.logger.Debug("captured exchange", "targetURI", warcTargetURI, "ip", IP, "responseType", responseType, "elapsed", time.Since(start))
+
+	// The connection has been upgraded to WebSocket, the frames are captured in both directions until it is closed
+	if len(webSocketReqCh) == 1 && len(webSocketRespCh) == 1 {
+		webSocketOpened = true
+
+		var (
+			reqReader  = <-webSocketReqCh
+			respReader = <-webSocketRespCh
+			framesErrs = errgroup.Group{}
+		)
+
+		framesErrs.Go(func() error {
+			return d.captureWebSocketFrames(ctx, reqReader, WebSocketClientToServer, warcTargetURI, responseID, IP)
+		})
+
+		framesErrs.Go(func() error {
+			return d.captureWebSocketFrames(ctx, respReader, WebSocketServerToClient, warcTargetURI, responseID, IP)
+		})
+
+		if framesErr := framesErrs.Wait(); framesErr != nil {
+			emit("", framesErr)
+		}
+	}
 }
 
-func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader, targetURIRxCh chan string, targetURITxCh chan string, recordChan chan *Record) error {
+func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader, targetURIRxCh chan string, targetURITxCh chan string, recordChan chan *Record, webSocketReqCh, webSocketRespCh chan *bufio.Reader) error {
 	defer close(targetURITxCh)
 
 	// Initialize the response record
@@ -427,40 +576,145 @@ func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader
 	responseRecord.Header.Set("WARC-Type", "response")
 	responseRecord.Header.Set("Content-Type", "application/http; msgtype=response")
 
-	// Read the response from the pipe
-	bytesCopied, err := io.Copy(responseRecord.Content, respPipe)
+	// Everything read from the pipe is spooled to the record's content while the
+	// response is parsed and its payload hashed, in a single pass. The body of a
+	// response expected to be a duplicate isn't spooled, see checkValidatedCapture
+	var (
+		spool       = &toggleWriter{w: responseRecord.Content}
+		bytesCopied = new(countingWriter)
+		respReader  = bufio.NewReader(io.TeeReader(respPipe, io.MultiWriter(spool, bytesCopied)))
+	)
+
+	// drain consumes what is left in the pipe, so that the connection is never blocked
+	drain := func() error {
+		_, err := io.Copy(io.Discard, respReader)
+		return err
+	}
+
+	resp, err := ReadHTTPResponse(respReader)
 	if err != nil {
-		closeErr := responseRecord.Content.Close()
+		drainErr := drain()
+		closeErr := d.abandonRecord(responseRecord, recordChan)
+		if drainErr != nil || closeErr != nil {
+			return fmt.Errorf("readResponse: ReadHTTPResponse failed and draining or closing content failed: %w", errors.Join(err, drainErr, closeErr))
+		}
+
+		return &parseError{err: err}
+	}
+
+	// Position of the end of the HTTP headers in the record's content
+	endOfHeadersOffset := bytesCopied.n - int64(respReader.Buffered())
+
+	// Grab the WARC-Target-URI and send it back for records post-processing
+	var warcTargetURI string
+	receiveTargetURI := func() error {
+		recv, ok := <-targetURIRxCh
+		if !ok {
+			return errors.New("readResponse: WARC-Target-URI channel closed due to readRequest error")
+		}
+
+		warcTargetURI = recv
+		targetURITxCh <- warcTargetURI
+
+		return nil
+	}
+
+	// WebSocket handshake: if the request asked for the upgrade, only the HTTP response is kept
+	// in the record and the rest of the connection is handed over to writeWARCFromConnection
+	if resp.StatusCode == http.StatusSwitchingProtocols && isWebSocketUpgrade(resp.Header) {
+		if err = receiveTargetURI(); err != nil {
+			drain()
+			d.abandonRecord(responseRecord, recordChan)
+			return err
+		}
+
+		if len(webSocketReqCh) == 1 {
+			spool.w = io.Discard
+
+			if err = d.truncateRecordContent(responseRecord, endOfHeadersOffset); err != nil {
+				drain()
+				return fmt.Errorf("readResponse: %s", err.Error())
+			}
+
+			if d.client.DiscardHook != nil {
+				if discarded, reason := d.client.DiscardHook(resp); discarded {
+					drain()
+					responseRecord.Content.Close()
+					return &DiscardHookError{URL: warcTargetURI, Reason: reason, Err: nil}
+				}
+			}
+
+			// There is no payload in a 101 response
+			responseRecord.Header.Set("WARC-Payload-Digest", "sha1:3I42H3S6NNFQ2MSVX7XZKYAYSCX5QBYJ")
+
+			webSocketRespCh <- respReader
+			recordChan <- responseRecord
+
+			return nil
+		}
+	}
+
+	// With local dedupe, a response with the validators of a prior capture of the same URL is
+	// expected to be a duplicate, its body is only hashed. The WARC-Target-URI is usually known
+	// by now, as readRequest sends it before draining the request
+	var expected revisitRecord
+	if d.client.dedupeOptions.LocalDedupe && endOfHeadersOffset+resp.ContentLength >= int64(d.client.dedupeOptions.SizeThreshold) {
+		select {
+		case recv, ok := <-targetURIRxCh:
+			if ok {
+				warcTargetURI = recv
+				targetURITxCh <- warcTargetURI
+			}
+		default:
+		}
+
+		if warcTargetURI != "" {
+			expected = d.checkValidatedCapture(warcTargetURI, resp)
+		}
+
+		if expected.digest != "" {
+			spool.w = io.Discard
+		}
+	}
+
+	// Calculate the WARC-Payload-Digest over the transfer-decoded entity-body, see payload.go
+	payloadDigest, digestErr := getDigest(resp.Body, "sha1")
+
+	// Whatever happened, consume the rest of the response
+	if err = drain(); err != nil {
+		closeErr := d.abandonRecord(responseRecord, recordChan)
 		if closeErr != nil {
 			return fmt.Errorf("readResponse: io.Copy failed and closing content failed: %s", closeErr.Error())
 		}
 
-		return fmt.Errorf("readResponse: io.Copy failed: %s", err.Error())
+		return fmt.Errorf("readResponse: io.Copy failed: %w", err)
 	}
 
 	select {
 	case <-ctx.Done():
+		d.abandonRecord(responseRecord, recordChan)
 		return ctx.Err()
 	default:
 	}
 
-	resp, err := http.ReadResponse(bufio.NewReader(responseRecord.Content), nil)
-	if err != nil {
-		closeErr := responseRecord.Content.Close()
-		if closeErr != nil {
-			return fmt.Errorf("readResponse: http.ReadResponse failed and closing content failed: %s", closeErr.Error())
+	if warcTargetURI == "" {
+		if err = receiveTargetURI(); err != nil {
+			d.abandonRecord(responseRecord, recordChan)
+			return err
 		}
-
-		return err
 	}
 
-	// Grab the WARC-Target-URI and send it back for records post-processing
-	var warcTargetURI, ok = <-targetURIRxCh
-	if !ok {
-		return errors.New("readResponse: WARC-Target-URI channel closed due to readRequest error")
+	// The body has been consumed while hashing it, give the hook a fresh reader over the spooled payload,
+	// unless it wasn't spooled
+	if expected.digest != "" {
+		resp.Body = http.NoBody
+	} else {
+		resp.Body, err = newPayloadReader(io.NewSectionReader(responseRecord.Content, endOfHeadersOffset, bytesCopied.n-endOfHeadersOffset), resp)
+	}
+	if err != nil {
+		d.abandonRecord(responseRecord, recordChan)
+		return &parseError{err: fmt.Errorf("readResponse: could not read payload: %s", err.Error())}
 	}
-
-	targetURITxCh <- warcTargetURI
 
 	// If the Discard Hook is set and returns true, discard the response
 	if d.client.DiscardHook == nil {
@@ -478,16 +732,14 @@ func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader
 		return &DiscardHookError{URL: warcTargetURI, Reason: reason, Err: nil}
 	}
 
-	// Calculate the WARC-Payload-Digest
-	payloadDigest := GetSHA1(resp.Body)
-	if strings.HasPrefix(payloadDigest, "ERROR: ") {
-		closeErr := responseRecord.Content.Close()
+	if digestErr != nil {
+		closeErr := d.abandonRecord(responseRecord, recordChan)
 		if closeErr != nil {
 			return fmt.Errorf("readResponse: SHA1 calculation failed and closing content failed: %s", closeErr.Error())
 		}
 
 		// This should _never_ happen.
-		return fmt.Errorf("readResponse: SHA1 ran into an unrecoverable error: %s url: %s", payloadDigest, warcTargetURI)
+		return fmt.Errorf("readResponse: SHA1 ran into an unrecoverable error: %w url: %s", digestErr, warcTargetURI)
 	}
 
 	err = resp.Body.Close()
@@ -495,21 +747,56 @@ func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader
 		return fmt.Errorf("readResponse: closing body after SHA1 calculation failed: %s", err.Error())
 	}
 
-	responseRecord.Header.Set("WARC-Payload-Digest", "sha1:"+payloadDigest)
+	responseRecord.Header.Set("WARC-Payload-Digest", payloadDigest)
+	payloadDigest = strings.TrimPrefix(payloadDigest, "sha1:")
+
+	// A 304 to a conditional request built from a prior capture is written as a server-not-modified revisit,
+	// the record keeps the whole response since it has no payload
+	if d.client.dedupeOptions.ConditionalRequests && resp.StatusCode == http.StatusNotModified {
+		if revisit := d.checkConditionalRevisit(warcTargetURI); revisit.responseUUID != "" {
+			responseRecord.Header.Set("WARC-Type", "revisit")
+			responseRecord.Header.Set("WARC-Refers-To", "<urn:uuid:"+revisit.responseUUID+">")
+			responseRecord.Header.Set("WARC-Refers-To-Target-URI", revisit.targetURI)
+			responseRecord.Header.Set("WARC-Refers-To-Date", revisit.date)
+			responseRecord.Header.Set("WARC-Profile", "http://netpreserve.org/warc/1.1/revisit/server-not-modified")
+			responseRecord.Header.Del("WARC-Payload-Digest")
+			d.client.metrics.IncRevisits("conditional")
+			d.client.logger.Debug("writing revisit", "kind", "conditional", "targetURI", warcTargetURI, "refersTo", revisit.responseUUID)
+
+			recordChan <- responseRecord
+
+			return nil
+		}
+	}
 
 	// Write revisit record if local or CDX dedupe is activated
-	var revisit = revisitRecord{}
-	if bytesCopied >= int64(d.client.dedupeOptions.SizeThreshold) {
+	var (
+		revisit     = revisitRecord{}
+		revisitKind = "local"
+	)
+
+	if bytesCopied.n >= int64(d.client.dedupeOptions.SizeThreshold) {
 		if d.client.dedupeOptions.LocalDedupe {
 			revisit = d.checkLocalRevisit(payloadDigest)
 
-			LocalDedupeTotal.Incr(int64(revisit.size))
+			d.client.LocalDedupeTotal.Incr(int64(revisit.size))
 		}
 
 		// Allow both to be checked. If local dedupe does not find anything, check CDX (if set).
 		if d.client.dedupeOptions.CDXDedupe && revisit.targetURI == "" {
-			revisit, _ = checkCDXRevisit(d.client.dedupeOptions.CDXURL, payloadDigest, warcTargetURI, d.client.dedupeOptions.CDXCookie)
-			RemoteDedupeTotal.Incr(int64(revisit.size))
+			revisitKind = "cdx"
+
+			var cdxErr error
+			revisit, cdxErr = d.client.checkCDXRevisit(d.client.dedupeOptions.CDXURL, payloadDigest, warcTargetURI, d.client.dedupeOptions.CDXCookie)
+			if cdxErr != nil {
+				d.client.emit(&Event{
+					Kind:      EventKindDedupe,
+					Err:       cdxErr,
+					Func:      "readResponse",
+					TargetURI: warcTargetURI,
+				})
+			}
+			d.client.RemoteDedupeTotal.Incr(int64(revisit.size))
 		}
 	}
 
@@ -524,102 +811,26 @@ func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader
 
 		responseRecord.Header.Set("WARC-Profile", "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest")
 		responseRecord.Header.Set("WARC-Truncated", "length")
-
-		// Find the position of the end of the headers
-		_, err := responseRecord.Content.Seek(0, 0)
-		if err != nil {
-			return fmt.Errorf("readResponse: could not seek to the beginning of the content: %s", err.Error())
-		}
-
-		found := false
-		bigBlock := make([]byte, 0, 4)
-		block := make([]byte, 1)
-		endOfHeadersOffset := 0
-		for {
-			n, err := responseRecord.Content.Read(block)
-			if n > 0 {
-				switch len(bigBlock) {
-				case 0:
-					if string(block) == "\r" {
-						bigBlock = append(bigBlock, block...)
-					}
-				case 1:
-					if string(block) == "\n" {
-						bigBlock = append(bigBlock, block...)
-					} else {
-						bigBlock = nil
-					}
-				case 2:
-					if string(block) == "\r" {
-						bigBlock = append(bigBlock, block...)
-					} else {
-						bigBlock = nil
-					}
-				case 3:
-					if string(block) == "\n" {
-						bigBlock = append(bigBlock, block...)
-						found = true
-					} else {
-						bigBlock = nil
-					}
-				}
-
-				endOfHeadersOffset++
-
-				if found {
-					break
-				}
-			}
-
-			if err == io.EOF {
-				break
-			}
-
-			if err != nil {
-				return err
-			}
-		}
+		d.client.metrics.IncRevisits(revisitKind)
+		d.client.logger.Debug("writing revisit", "kind", revisitKind, "targetURI", warcTargetURI, "digest", payloadDigest, "refersToTargetURI", revisit.targetURI, "refersToDate", revisit.date)
 
 		// This should really never happen! This could be the result of a malfunctioning HTTP server or something currently unknown!
-		if endOfHeadersOffset == -1 {
+		if endOfHeadersOffset <= 0 {
+			responseRecord.Content.Close()
 			return errors.New("readResponse: could not find the end of the headers")
 		}
 
-		// Write the data up until the end of the headers to a temporary buffer
-		tempBuffer := spooledtempfile.NewSpooledTempFile("warc", d.client.TempDir, -1, d.client.FullOnDisk, d.client.MaxRAMUsageFraction)
-		block = make([]byte, 1)
-		wrote := 0
-		responseRecord.Content.Seek(0, 0)
-		for {
-			n, err := responseRecord.Content.Read(block)
-			if n > 0 {
-				_, err = tempBuffer.Write(block)
-				if err != nil {
-					return fmt.Errorf("readResponse: could not write to temporary buffer: %s", err.Error())
-				}
-			}
-
-			if err == io.EOF {
-				break
-			}
-
-			if err != nil {
-				return fmt.Errorf("readResponse: could not read from response content: %s", err.Error())
-			}
-
-			wrote++
-
-			if wrote == endOfHeadersOffset {
-				break
-			}
+		// Only keep the HTTP headers, the offset of their end is already known so there is nothing to scan.
+		// The spooled body is released here, before the record waits in the rotator queue
+		if err = d.truncateRecordContent(responseRecord, endOfHeadersOffset); err != nil {
+			return fmt.Errorf("readResponse: %s", err.Error())
 		}
+	}
 
-		// Close old buffer
-		err = responseRecord.Content.Close()
-		if err != nil {
-			return fmt.Errorf("readResponse: could not close old content buffer: %s", err.Error())
-		}
-		responseRecord.Content = tempBuffer
+	// The body of a response expected to be a duplicate is lost if it wasn't one
+	if expected.digest != "" && responseRecord.Header.Get("WARC-Type") != "revisit" {
+		d.abandonRecord(responseRecord, recordChan)
+		return fmt.Errorf("readResponse: the payload digest %s differs from %s, the one of the prior capture with the same validators", payloadDigest, expected.digest)
 	}
 
 	recordChan <- responseRecord
@@ -627,118 +838,124 @@ func (d *customDialer) readResponse(ctx context.Context, respPipe *io.PipeReader
 	return nil
 }
 
-func (d *customDialer) readRequest(ctx context.Context, scheme string, reqPipe *io.PipeReader, targetURITxCh chan string, recordChan chan *Record) error {
+func (d *customDialer) readRequest(ctx context.Context, scheme string, reqPipe *io.PipeReader, targetURITxCh chan string, recordChan chan *Record, webSocketReqCh chan *bufio.Reader) error {
 	defer close(targetURITxCh)
 
-	var (
-		warcTargetURI = scheme + "://"
-		requestRecord = NewRecord(d.client.TempDir, d.client.FullOnDisk)
-	)
-
 	// Initialize the request record
+	var requestRecord = NewRecord(d.client.TempDir, d.client.FullOnDisk)
 	requestRecord.Header.Set("WARC-Type", "request")
 	requestRecord.Header.Set("Content-Type", "application/http; msgtype=request")
 
-	// Copy the content from the pipe
-	_, err := io.Copy(requestRecord.Content, reqPipe)
-	if err != nil {
-		return fmt.Errorf("readRequest: io.Copy failed: %s", err.Error())
-	}
-
-	// Seek to the beginning of the content to allow reading
-	if _, err := requestRecord.Content.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("readRequest: seek failed: %s", err.Error())
-	}
-
-	// Use a buffered reader for efficient parsing
-	reader := bufio.NewReaderSize(requestRecord.Content, 4096) // 4KB buffer
-
-	// State machine to parse the request
-	const (
-		stateRequestLine = iota
-		stateHeaders
-	)
-
+	// Everything read from the pipe is spooled to the record's content while the request is parsed
 	var (
-		target      string
-		host        string
-		state       = stateRequestLine
-		foundHost   = false
-		foundTarget = false
+		spool       = &toggleWriter{w: requestRecord.Content}
+		bytesCopied = new(countingWriter)
+		reqReader   = bufio.NewReader(io.TeeReader(reqPipe, io.MultiWriter(spool, bytesCopied)))
 	)
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("readRequest: failed to read line: %v", err)
-		}
+	// drain consumes what is left in the pipe, so that the connection is never blocked
+	drain := func() error {
+		_, err := io.Copy(io.Discard, reqReader)
+		return err
+	}
 
-		line = strings.TrimSpace(line)
+	req, err := http.ReadRequest(reqReader)
+	if err != nil {
+		drain()
+		d.abandonRecord(requestRecord, recordChan)
+		return &parseError{err: fmt.Errorf("unable to parse data necessary for WARC-Target-URI: %w", err)}
+	}
 
-		switch state {
-		case stateRequestLine:
-			// Parse the request line (e.g., "GET /path HTTP/1.1")
-			if isHTTPRequest(line) {
-				parts := strings.Split(line, " ")
-				if len(parts) >= 2 {
-					target = parts[1] // Extract the target (path)
-					foundTarget = true
-				}
-				state = stateHeaders
-			}
-		case stateHeaders:
-			// Parse headers (e.g., "Host: example.com")
-			if line == "" {
-				break // End of headers
-			}
+	// The body is only read to find the end of the request, it is already spooled
+	io.Copy(io.Discard, req.Body)
 
-			if strings.HasPrefix(line, "Host: ") {
-				host = strings.TrimPrefix(line, "Host: ")
-				foundHost = true
-			}
-		}
+	// Position of the end of the HTTP request in the record's content
+	endOfRequestOffset := bytesCopied.n - int64(reqReader.Buffered())
 
-		// If we've found both the target and host, we can stop parsing
-		if foundHost && foundTarget {
-			break
-		}
+	warcTargetURI, err := buildWARCTargetURI(scheme, req)
+	if err != nil {
+		drain()
+		d.abandonRecord(requestRecord, recordChan)
+		return &parseError{err: err}
 	}
 
-	// Check that we successfully parsed all necessary data
-	if host != "" && target != "" {
-		// HTTP's request first line can include a complete path, we check that
-		if strings.HasPrefix(target, scheme+"://"+host) {
-			warcTargetURI = target
-		} else {
-			warcTargetURI += host + target
+	// If the request asks for an upgrade to WebSocket, what follows it on the connection
+	// isn't part of the request: the reader is handed over so that the frames can be captured,
+	// and it must be before sending the WARC-Target-URI so that readResponse() knows about it
+	upgraded := isWebSocketUpgrade(req.Header)
+	if upgraded {
+		spool.w = io.Discard
+
+		if err = d.truncateRecordContent(requestRecord, endOfRequestOffset); err != nil {
+			drain()
+			return fmt.Errorf("readRequest: %s", err.Error())
 		}
-	} else {
-		return errors.New("unable to parse data necessary for WARC-Target-URI")
+
+		webSocketReqCh <- reqReader
 	}
 
-	// Send the WARC-Target-URI to a channel so that it can be picked up
-	// by the goroutine responsible for writing the response
+	// Send the WARC-Target-URI to a channel so that it can be picked up by the goroutine
+	// responsible for writing the response, before draining the connection so that it is
+	// usually known once the response headers are read
 	select {
 	case <-ctx.Done():
+		if !upgraded {
+			drain()
+		}
+		d.abandonRecord(requestRecord, recordChan)
 		return ctx.Err()
 	case targetURITxCh <- warcTargetURI:
 	}
 
+	// The rest of an upgraded connection was handed over with the reader
+	if !upgraded {
+		if err = drain(); err != nil {
+			d.abandonRecord(requestRecord, recordChan)
+			return fmt.Errorf("readRequest: io.Copy failed: %w", err)
+		}
+	}
+
 	// Send the request record to the channel for further processing
 	select {
 	case <-ctx.Done():
+		d.abandonRecord(requestRecord, recordChan)
 		return ctx.Err()
 	case recordChan <- requestRecord:
 	}
 
 	return nil
 }
+
+// abandonRecord closes the content of a record which capture failed, unless failed
+// exchanges are archived, in which case it is sent to writeWARCFromConnection.
+func (d *customDialer) abandonRecord(record *Record, recordChan chan *Record) error {
+	if !d.client.archiveFailures {
+		return record.Content.Close()
+	}
+
+	// The reason is refined by writeWARCFromConnection once the error is known
+	record.Header.Set("WARC-Truncated", "unspecified")
+	recordChan <- record
+
+	return nil
+}
+
+// truncateRecordContent replaces the content of the record with its first length bytes.
+func (d *customDialer) truncateRecordContent(record *Record, length int64) error {
+	truncated := spooledtempfile.NewSpooledTempFile("warc", d.client.TempDir, -1, d.client.FullOnDisk, d.client.MaxRAMUsageFraction)
+
+	if _, err := io.Copy(truncated, io.NewSectionReader(record.Content, 0, length)); err != nil {
+		truncated.Close()
+		record.Content.Close()
+		return fmt.Errorf("could not write to temporary buffer: %s", err.Error())
+	}
+
+	if err := record.Content.Close(); err != nil {
+		truncated.Close()
+		return fmt.Errorf("could not close old content buffer: %s", err.Error())
+	}
+
+	record.Content = truncated
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DictionaryOptions tells how TrainZStdDictionary samples records and builds
+// the dictionary. The zero value uses the defaults.
+type DictionaryOptions struct {
+	// MaxSize is the maximum size of the dictionary in bytes, default 112KB
+	MaxSize int
+	// MaxSamples is the number of records sampled, uniformly among the records
+	// matching the filters, default 10000
+	MaxSamples int
+	// MaxSampleSize is the number of bytes of a record used for training, its
+	// WARC header included, default 32KB
+	MaxSampleSize int
+	// MIMETypes only samples records which payload has one of these MIME types.
+	// A type ending with a slash, like "text/", matches all its subtypes
+	MIMETypes []string
+	// Hosts only samples records which WARC-Target-URI has one of these hosts
+	Hosts []string
+}
+
+// TrainZStdDictionary samples records from the WARC files at paths and trains a
+// ZStd dictionary on them. The dictionary can be written to a file to be used
+// as RotatorSettings.CompressionDictionary, warcinfo records are never sampled.
+func TrainZStdDictionary(paths []string, options DictionaryOptions) ([]byte, error) {
+	if options.MaxSize == 0 {
+		options.MaxSize = 112 << 10
+	}
+
+	if options.MaxSamples == 0 {
+		options.MaxSamples = 10000
+	}
+
+	if options.MaxSampleSize == 0 {
+		options.MaxSampleSize = 32 << 10
+	}
+
+	var (
+		samples [][]byte
+		seen    int
+	)
+
+	for _, path := range paths {
+		err := readDictionarySamples(path, options, func(sample []byte) {
+			// Reservoir sampling, so that every record has the same chance to be kept
+			seen++
+			if len(samples) < options.MaxSamples {
+				samples = append(samples, sample)
+			} else if i := rand.IntN(seen); i < options.MaxSamples {
+				samples[i] = sample
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, errors.New("no record to train the dictionary on")
+	}
+
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize:    options.MaxSize,
+		HashBytes:      6,
+		ZstdDictCompat: true,
+		ZstdLevel:      zstd.SpeedBetterCompression,
+	})
+}
+
+// readDictionarySamples calls sample with the beginning of every record of the
+// WARC file at path matching the filters of options.
+func readDictionarySamples(path string, options DictionaryOptions, sample func([]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if record.Header.Get("WARC-Type") != "warcinfo" && matchesDictionaryFilters(record, options) {
+			// The sample is the record as the writer compresses it
+			var buf bytes.Buffer
+			buf.WriteString("WARC/1.1\r\n")
+			for key, value := range record.Header {
+				fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+			}
+			buf.WriteString("\r\n")
+
+			if _, err := record.Content.Seek(0, io.SeekStart); err != nil {
+				record.Content.Close()
+				return err
+			}
+
+			if remaining := options.MaxSampleSize - buf.Len(); remaining > 0 {
+				if _, err := io.CopyN(&buf, record.Content, int64(remaining)); err != nil && err != io.EOF {
+					record.Content.Close()
+					return err
+				}
+			}
+
+			sample(buf.Bytes()[:min(buf.Len(), options.MaxSampleSize)])
+		}
+
+		record.Content.Close()
+	}
+}
+
+// matchesDictionaryFilters tells if record matches the host and MIME type filters of options.
+func matchesDictionaryFilters(record *Record, options DictionaryOptions) bool {
+	if len(options.Hosts) > 0 {
+		targetURI, err := url.Parse(record.Header.Get("WARC-Target-URI"))
+		if err != nil || !slices.ContainsFunc(options.Hosts, func(host string) bool { return strings.EqualFold(host, targetURI.Hostname()) }) {
+			return false
+		}
+	}
+
+	if len(options.MIMETypes) > 0 {
+		mimeType := recordMIMEType(record)
+
+		return slices.ContainsFunc(options.MIMETypes, func(filter string) bool {
+			filter = strings.ToLower(filter)
+			if strings.HasSuffix(filter, "/") {
+				return strings.HasPrefix(mimeType, filter)
+			}
+			return mimeType == filter
+		})
+	}
+
+	return true
+}
+
+// recordMIMEType returns the MIME type of the payload of record: the Content-Type
+// of the HTTP response for response records, else the Content-Type of the record.
+func recordMIMEType(record *Record) string {
+	contentType := record.Header.Get("Content-Type")
+
+	if record.Header.Get("WARC-Type") == "response" && strings.HasPrefix(contentType, "application/http") {
+		contentType = ""
+
+		if _, err := record.Content.Seek(0, io.SeekStart); err == nil {
+			if resp, err := ReadHTTPResponse(bufio.NewReader(record.Content)); err == nil {
+				contentType = resp.Header.Get("Content-Type")
+				resp.Body.Close()
+			}
+		}
+	}
+
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return mimeType
+}
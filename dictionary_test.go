@@ -0,0 +1,122 @@
+package warc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeDictionaryTestWARC writes a WARC file of HTML and JSON resource records from two hosts.
+func writeDictionaryTestWARC(t *testing.T) []string {
+	rotatorSettings := defaultRotatorSettings(t)
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		batch := NewRecordBatch(make(chan struct{}, 1))
+
+		record := NewRecord("", false)
+		record.Header.Set("WARC-Type", "resource")
+		if i%2 == 0 {
+			record.Header.Set("WARC-Target-URI", fmt.Sprintf("http://a.example.com/page/%d", i))
+			record.Header.Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(record.Content, "<!DOCTYPE html><html><head><title>Page %d</title></head><body>%s</body></html>", i, strings.Repeat(fmt.Sprintf("<p class=\"paragraph\">Paragraph %d of the page</p>", i*7), 20))
+		} else {
+			record.Header.Set("WARC-Target-URI", fmt.Sprintf("http://b.example.com/api/%d", i))
+			record.Header.Set("Content-Type", "application/json")
+			fmt.Fprintf(record.Content, `{"id": %d, "items": [%s]}`, i, strings.Repeat(fmt.Sprintf(`{"name": "item %d", "value": %d},`, i, i*3), 20))
+		}
+
+		batch.Records = []*Record{record}
+		records <- batch
+		<-batch.FeedbackChan
+	}
+
+	closeTestRotator(records, doneChannels)
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc.gz"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v (%v)", paths, err)
+	}
+
+	return paths
+}
+
+func TestTrainZStdDictionary(t *testing.T) {
+	paths := writeDictionaryTestWARC(t)
+
+	dictionary, err := TrainZStdDictionary(paths, DictionaryOptions{MaxSize: 8 << 10, MIMETypes: []string{"text/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dictionary) == 0 || len(dictionary) > 8<<10 {
+		t.Fatalf("unexpected dictionary size %d", len(dictionary))
+	}
+
+	dictionaryPath := filepath.Join(t.TempDir(), "dictionary")
+	if err := os.WriteFile(dictionaryPath, dictionary, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dictionary is embedded in the WARC files written with it, and used to read them
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.Compression = "ZSTD"
+	rotatorSettings.CompressionDictionary = dictionaryPath
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 2, 2, 1000)
+	closeTestRotator(records, doneChannels)
+
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); !slices.Equal(counts, []int{4}) {
+		t.Errorf("expected 1 file of 4 records, got %v", counts)
+	}
+}
+
+func TestDictionaryFilters(t *testing.T) {
+	paths := writeDictionaryTestWARC(t)
+
+	for _, test := range []struct {
+		options  DictionaryOptions
+		expected int
+	}{
+		{DictionaryOptions{}, 200},
+		{DictionaryOptions{MIMETypes: []string{"text/html"}}, 100},
+		{DictionaryOptions{MIMETypes: []string{"application/"}}, 100},
+		{DictionaryOptions{MIMETypes: []string{"text/plain"}}, 0},
+		{DictionaryOptions{Hosts: []string{"B.example.com"}}, 100},
+		{DictionaryOptions{Hosts: []string{"a.example.com"}, MIMETypes: []string{"application/json"}}, 0},
+	} {
+		test.options.MaxSampleSize = 1 << 10
+
+		count := 0
+		err := readDictionarySamples(paths[0], test.options, func(sample []byte) {
+			count++
+
+			if len(sample) > 1<<10 || !strings.HasPrefix(string(sample), "WARC/1.1\r\n") {
+				t.Errorf("unexpected sample of %d bytes", len(sample))
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != test.expected {
+			t.Errorf("%+v: expected %d samples, got %d", test.options, test.expected, count)
+		}
+	}
+
+	if _, err := TrainZStdDictionary(paths, DictionaryOptions{Hosts: []string{"c.example.com"}}); err == nil {
+		t.Error("expected an error when no record matches")
+	}
+}
@@ -20,7 +20,9 @@ func (e *DiscardHookError) Unwrap() error {
 }
 
 // DiscardHook is a hook function that is called for each response. (if set)
-// It can be used to determine if the response should be discarded.
+// It can be used to determine if the response should be discarded. The body of a
+// response expected to be a duplicate with local dedupe isn't kept, the hook gets
+// an empty body for it.
 // Returns:
 //   - bool: should the response be discarded
 //   - string: (optional) why the response was discarded or not
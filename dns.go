@@ -26,9 +26,13 @@ func (d *customDialer) archiveDNS(ctx context.Context, address string) (resolved
 
 	// Check cache first
 	if cachedIP, ok := d.DNSRecords.Get(address); ok {
+		d.client.metrics.IncDNSLookups(true)
+		d.client.logger.Debug("DNS cache hit", "host", address, "ip", cachedIP)
 		return cachedIP, true, nil
 	}
 
+	d.client.metrics.IncDNSLookups(false)
+
 	var wg sync.WaitGroup
 	var ipv4, ipv6 net.IP
 	var errA, errAAAA error
@@ -63,6 +67,7 @@ func (d *customDialer) archiveDNS(ctx context.Context, address string) (resolved
 		}
 	}
 	if errA != nil && errAAAA != nil {
+		d.client.logger.Debug("DNS resolution failed", "host", address, "errA", errA, "errAAAA", errAAAA)
 		return nil, false, fmt.Errorf("failed to resolve DNS: A error: %v, AAAA error: %v", errA, errAAAA)
 	}
 
@@ -76,6 +81,7 @@ func (d *customDialer) archiveDNS(ctx context.Context, address string) (resolved
 	if resolvedIP != nil {
 		// Cache the result
 		d.DNSRecords.Set(address, resolvedIP)
+		d.client.logger.Debug("DNS resolved", "host", address, "ip", resolvedIP)
 		return resolvedIP, false, nil
 	}
 
@@ -187,3 +187,81 @@ func TestDNSCaching(t *testing.T) {
 		t.Error("Expected cached result")
 	}
 }
+
+func TestDNSArchivingSyncOnClose(t *testing.T) {
+	// A local DNS server resolving every name to 127.0.0.1
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &dns.Server{PacketConn: packetConn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("127.0.0.1"),
+			})
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.SyncPolicy = SyncOnClose
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+	httpClient.closeDNSCache()
+
+	d := newTestCustomDialer()
+	d.client = httpClient
+	defer func() {
+		d.DNSRecords.Close()
+		time.Sleep(1 * time.Second)
+	}()
+
+	_, d.DNSConfig.Port, _ = net.SplitHostPort(packetConn.LocalAddr().String())
+	d.DNSConfig.Servers = []string{"127.0.0.1"}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// The DNS records are archived while dialing, which mustn't wait for the file to be closed
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := d.CustomDialContext(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		if err == nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+
+	select {
+	case err := <-dialed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dial is blocked by the archiving of its DNS records")
+	}
+
+	if err := httpClient.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); len(counts) != 1 || counts[0] < 1 {
+		t.Errorf("expected the DNS records in 1 file, got %v", counts)
+	}
+}
@@ -0,0 +1,41 @@
+package warc
+
+import (
+	"os"
+)
+
+// SyncPolicy tells when the writers of a rotator fsync their WARC files. The
+// FeedbackChan of a RecordBatch is only signalled once its records are durable
+// under the policy, so that a capture acknowledged by the rotator survives a
+// power loss.
+type SyncPolicy int
+
+const (
+	// SyncNone never syncs the files and signals the FeedbackChan of a batch as
+	// soon as it is written, leaving durability to the operating system. It is the default
+	SyncNone SyncPolicy = iota
+	// SyncEveryBatch syncs the file after every batch
+	SyncEveryBatch
+	// SyncEveryBytes syncs the file once RotatorSettings.SyncBytes bytes were
+	// written since the last sync, or when no batch is waiting to be written,
+	// so that feedback is never held back while the rotator is idle
+	SyncEveryBytes
+	// SyncOnClose only syncs a file when it is closed: the FeedbackChan of a
+	// batch is signalled when the file it was written to is finalized. The
+	// records of CustomHTTPClient.WriteRecord, like DNS records, are only
+	// waited for until they are written, as they are written while dialing.
+	// This exception only applies to SyncOnClose
+	SyncOnClose
+)
+
+// syncDir fsyncs the directory at path, making the creation and renaming of
+// its files durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
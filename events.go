@@ -0,0 +1,154 @@
+package warc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultEventsBufferSize is the capacity of CustomHTTPClient.Events (and of the
+// deprecated ErrChan) when HTTPClientSettings.EventsBufferSize isn't set.
+const DefaultEventsBufferSize = 1024
+
+// EventKind tells what part of the capture an Event is about, so that
+// consumers can act on specific failures without matching error strings.
+type EventKind string
+
+const (
+	// EventKindDNS is emitted when a hostname couldn't be resolved
+	EventKindDNS EventKind = "dns"
+	// EventKindDial is emitted when a connection couldn't be established
+	EventKindDial EventKind = "dial"
+	// EventKindTLS is emitted when the TLS handshake failed
+	EventKindTLS EventKind = "tls"
+	// EventKindConnection is emitted when an established connection failed (reset, timeout, early close)
+	EventKindConnection EventKind = "connection"
+	// EventKindParse is emitted when the captured request or response isn't valid HTTP
+	EventKindParse EventKind = "parse"
+	// EventKindDiscard is emitted when the DiscardHook discarded a response
+	EventKindDiscard EventKind = "discard"
+	// EventKindWrite is emitted when the records of a capture couldn't be prepared for the WARC writer
+	EventKindWrite EventKind = "write"
+	// EventKindDedupe is emitted when looking for, or storing, a duplicate failed
+	EventKindDedupe EventKind = "dedupe"
+	// EventKindOther is emitted for any other error
+	EventKindOther EventKind = "other"
+)
+
+// Event describes something that went wrong during a capture.
+type Event struct {
+	// Time is when the event happened
+	Time time.Time
+	Err  error
+	Kind EventKind
+	// Func is the name of the function that emitted the event
+	Func string
+	// TargetURI is the URI being captured, empty if it isn't known yet
+	TargetURI string
+	// Elapsed is the time between the start of the exchange (request sent or
+	// connection established) and the event
+	Elapsed time.Duration
+}
+
+// tlsHandshakeError wraps the errors of the TLS handshake so that they can be told apart from dial errors.
+type tlsHandshakeError struct {
+	err error
+}
+
+func (e *tlsHandshakeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tlsHandshakeError) Unwrap() error {
+	return e.err
+}
+
+// parseError wraps the errors returned when a captured HTTP message can't be parsed.
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// eventKind returns the EventKind matching err.
+func eventKind(err error) EventKind {
+	var (
+		discardErr *DiscardHookError
+		dnsErr     *dnsResolutionError
+		netDNSErr  *net.DNSError
+		tlsErr     *tlsHandshakeError
+		opErr      *net.OpError
+		parseErr   *parseError
+	)
+
+	switch {
+	case errors.As(err, &discardErr):
+		return EventKindDiscard
+	case errors.As(err, &dnsErr), errors.As(err, &netDNSErr):
+		return EventKindDNS
+	case errors.As(err, &tlsErr):
+		return EventKindTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return EventKindDial
+	case errors.As(err, &opErr), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF):
+		return EventKindConnection
+	case errors.As(err, &parseErr):
+		return EventKindParse
+	default:
+		return EventKindOther
+	}
+}
+
+// DroppedEvents returns the number of events that were dropped because Events was full.
+func (c *CustomHTTPClient) DroppedEvents() uint64 {
+	return c.droppedEvents.Load()
+}
+
+// DroppedErrors returns the number of errors that were dropped because ErrChan was full.
+func (c *CustomHTTPClient) DroppedErrors() uint64 {
+	return c.droppedErrors.Load()
+}
+
+// emit delivers an event to the EventHandler and Events without ever blocking:
+// when Events is full, the event is dropped and counted.
+func (c *CustomHTTPClient) emit(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if event.Kind == "" {
+		event.Kind = eventKind(event.Err)
+	}
+
+	c.logger.Debug("capture event", "kind", event.Kind, "func", event.Func, "targetURI", event.TargetURI, "elapsed", event.Elapsed, "err", event.Err)
+
+	if c.eventHandler != nil {
+		c.eventHandler(event)
+	}
+
+	select {
+	case c.Events <- event:
+	default:
+		c.droppedEvents.Add(1)
+	}
+}
+
+// emitError is emit for the errors that are also sent on the deprecated ErrChan,
+// which is written the same way, dropping errors when it is full.
+func (c *CustomHTTPClient) emitError(event *Event) {
+	c.emit(event)
+
+	select {
+	case c.ErrChan <- &Error{Err: event.Err, Func: event.Func}:
+	default:
+		c.droppedErrors.Add(1)
+	}
+}
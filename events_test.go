@@ -0,0 +1,150 @@
+package warc
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHTTPClientEvents(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		handled         = make(map[EventKind]*Event)
+		handledMutex    sync.Mutex
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/garbage" {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			conn.Write([]byte("HTTP/1.1 2OO OK\r\n\r\n"))
+			conn.Close()
+			return
+		}
+
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	// An address nothing listens on
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + closedListener.Addr().String() + "/closed"
+	closedListener.Close()
+
+	// Nothing reads Events nor ErrChan until the client is closed, the capture must not block
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		DiscardHook: func(resp *http.Response) (bool, string) {
+			return resp.StatusCode == http.StatusTooManyRequests, "429"
+		},
+		EventHandler: func(event *Event) {
+			handledMutex.Lock()
+			defer handledMutex.Unlock()
+			handled[event.Kind] = event
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	if _, err = httpClient.Get(closedURL); err == nil {
+		t.Fatal("expected the request to a closed port to fail")
+	}
+
+	for _, path := range []string{"/discarded", "/garbage"} {
+		resp, err := httpClient.Get(server.URL + path)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	httpClient.Close()
+
+	var received int
+	for event := range httpClient.Events {
+		if handled[event.Kind] == nil {
+			t.Errorf("event %v sent on Events wasn't given to the EventHandler", event)
+		}
+		received++
+	}
+
+	if received != len(handled) {
+		t.Errorf("expected %d events on Events, got %d", len(handled), received)
+	}
+
+	expectedURIs := map[EventKind]string{
+		EventKindDial:    closedURL,
+		EventKindDiscard: server.URL + "/discarded",
+		EventKindParse:   server.URL + "/garbage",
+	}
+
+	for kind, uri := range expectedURIs {
+		event, ok := handled[kind]
+		if !ok {
+			t.Errorf("expected a %s event, got %v", kind, handled)
+			continue
+		}
+
+		if event.TargetURI != uri {
+			t.Errorf("unexpected target URI %q for the %s event, expected %q", event.TargetURI, kind, uri)
+		}
+
+		if event.Err == nil || event.Func == "" || event.Time.IsZero() || event.Elapsed <= 0 {
+			t.Errorf("incomplete %s event: %+v", kind, event)
+		}
+	}
+
+	// Only the errors of the capture itself are sent on the deprecated ErrChan
+	var errs int
+	for range httpClient.ErrChan {
+		errs++
+	}
+
+	if errs != 2 {
+		t.Errorf("expected 2 errors on ErrChan, got %d", errs)
+	}
+}
+
+func TestHTTPClientDroppedEvents(t *testing.T) {
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + closedListener.Addr().String()
+	closedListener.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings:  defaultRotatorSettings(t),
+		EventsBufferSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = httpClient.Get(closedURL); err == nil {
+			t.Fatal("expected the request to a closed port to fail")
+		}
+	}
+
+	httpClient.Close()
+
+	if httpClient.DroppedEvents() != 2 {
+		t.Errorf("expected 2 dropped events, got %d", httpClient.DroppedEvents())
+	}
+
+	if len(httpClient.Events) != 1 {
+		t.Errorf("expected 1 buffered event, got %d", len(httpClient.Events))
+	}
+}
@@ -0,0 +1,97 @@
+package warc
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// When HTTPClientSettings.ArchiveFailures is set, exchanges that couldn't be
+// captured are written instead of being dropped. The bytes that went through
+// the connection before the failure are written as request and response records
+// marked with WARC-Truncated, followed by a metadata record describing the error
+// that is concurrent to them. Failures that happen before a connection exists
+// (DNS resolution, dial, TLS handshake) only produce the metadata record.
+
+// dnsResolutionError wraps the errors returned by archiveDNS so that they can be told apart from dial errors.
+type dnsResolutionError struct {
+	err error
+}
+
+func (e *dnsResolutionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dnsResolutionError) Unwrap() error {
+	return e.err
+}
+
+// failureKind returns a short description of the kind of failure err is:
+// "dns", "refused", "reset", "timeout", "tls", "disconnect", "aborted" or "other".
+func failureKind(err error) string {
+	var (
+		dnsErr         *dnsResolutionError
+		netDNSErr      *net.DNSError
+		netErr         net.Error
+		unknownAuthErr x509.UnknownAuthorityError
+		certInvalidErr x509.CertificateInvalidError
+		hostnameErr    x509.HostnameError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr), errors.As(err, &netDNSErr):
+		return "dns"
+	case errors.Is(err, context.Canceled):
+		return "aborted"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout(), strings.Contains(err.Error(), "timeout"):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.As(err, &unknownAuthErr), errors.As(err, &certInvalidErr), errors.As(err, &hostnameErr), strings.Contains(err.Error(), "tls: "):
+		return "tls"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "disconnect"
+	default:
+		return "other"
+	}
+}
+
+// truncationReason returns the WARC-Truncated value of a record which capture was interrupted by err.
+func truncationReason(err error) string {
+	switch failureKind(err) {
+	case "timeout":
+		return "time"
+	case "reset", "disconnect":
+		return "disconnect"
+	default:
+		return "unspecified"
+	}
+}
+
+// newFailureRecord returns a metadata record describing the failure of the capture of targetURI.
+func (c *CustomHTTPClient) newFailureRecord(targetURI string, err error) *Record {
+	record := NewRecord(c.TempDir, c.FullOnDisk)
+	record.Header.Set("WARC-Type", "metadata")
+	record.Header.Set("WARC-Target-URI", targetURI)
+	record.Header.Set("Content-Type", "application/warc-fields")
+
+	fmt.Fprintf(record.Content, "error-kind: %s\r\n", failureKind(err))
+	fmt.Fprintf(record.Content, "error: %s\r\n", strings.ReplaceAll(err.Error(), "\n", " "))
+
+	return record
+}
+
+// archiveConnectionFailure writes a metadata record for a request that failed before a connection was established.
+func (c *CustomHTTPClient) archiveConnectionFailure(targetURI string, err error) {
+	batch := NewRecordBatch(nil)
+	batch.Records = []*Record{c.newFailureRecord(targetURI, err)}
+
+	c.rotatorSettings.SendBatch(context.Background(), batch, false)
+}
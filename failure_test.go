@@ -0,0 +1,185 @@
+package warc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestFailureKind(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{&dnsResolutionError{err: errors.New("no suitable IP address found for example.com")}, "dns"},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, "refused"},
+		{fmt.Errorf("readResponse: io.Copy failed: %w", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), "reset"},
+		{errors.New("TLS handshake timeout"), "timeout"},
+		{context.DeadlineExceeded, "timeout"},
+		{context.Canceled, "aborted"},
+		{errors.New("remote error: tls: handshake failure"), "tls"},
+		{io.ErrUnexpectedEOF, "disconnect"},
+		{errors.New("something else"), "other"},
+	}
+
+	for _, tc := range testCases {
+		if kind := failureKind(tc.err); kind != tc.expected {
+			t.Errorf("failureKind(%q) = %s, expected %s", tc.err, kind, tc.expected)
+		}
+	}
+}
+
+func TestHTTPClientArchiveFailures(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+	)
+
+	// A server that sends half of the announced body then resets the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		buf := make([]byte, 4096)
+		conn.Read(buf)
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\nonly a part of the body"))
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}()
+
+	// An address nothing listens on
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + closedListener.Addr().String() + "/closed"
+	closedListener.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		ArchiveFailures: true,
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for range httpClient.ErrChan {
+			// Errors are expected, they are what is being archived
+		}
+	}()
+
+	if _, err = httpClient.Get(closedURL); err == nil {
+		t.Fatal("expected the request to a closed port to fail")
+	}
+
+	resetURL := "http://" + listener.Addr().String() + "/reset"
+	resp, err := httpClient.Get(resetURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = io.Copy(io.Discard, resp.Body); err == nil {
+		t.Fatal("expected reading the body to fail")
+	}
+	resp.Body.Close()
+
+	httpClient.Close()
+	errWg.Wait()
+
+	files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		records  = make(map[string]*Record)
+		failures = make(map[string]string)
+	)
+
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := io.ReadAll(record.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record.Content.Close()
+
+			switch record.Header.Get("WARC-Type") {
+			case "metadata":
+				failures[record.Header.Get("WARC-Target-URI")] = string(content)
+				records[record.Header.Get("WARC-Target-URI")+" metadata"] = record
+			case "request", "response":
+				records[record.Header.Get("WARC-Record-ID")] = record
+				records[record.Header.Get("WARC-Target-URI")+" "+record.Header.Get("WARC-Type")] = record
+			}
+		}
+	}
+
+	if !strings.Contains(failures[closedURL], "error-kind: refused\r\n") {
+		t.Errorf("expected a refused connection to be archived for %s, got %q", closedURL, failures[closedURL])
+	}
+
+	if !strings.Contains(failures[resetURL], "error-kind: reset\r\n") {
+		t.Errorf("expected a reset connection to be archived for %s, got %q", resetURL, failures[resetURL])
+	}
+
+	response, ok := records[resetURL+" response"]
+	if !ok {
+		t.Fatal("the partial response wasn't archived")
+	}
+
+	if response.Header.Get("WARC-Truncated") != "disconnect" {
+		t.Errorf("expected the partial response to be truncated because of a disconnect, got %q", response.Header.Get("WARC-Truncated"))
+	}
+
+	request, ok := records[resetURL+" request"]
+	if !ok {
+		t.Fatal("the request of the failed exchange wasn't archived")
+	}
+
+	if request.Header.Get("WARC-Truncated") != "" {
+		t.Errorf("the request was complete and shouldn't be truncated")
+	}
+
+	if records[resetURL+" metadata"].Header.Get("WARC-Concurrent-To") != request.Header.Get("WARC-Record-ID") {
+		t.Errorf("the metadata record should be concurrent to the failed exchange")
+	}
+}
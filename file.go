@@ -1,70 +1,182 @@
 package warc
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
-var filenameGenerationLock = sync.Mutex{}
+// DefaultFilenameTemplate names WARC files following the recommendations of
+// the specs: Prefix-Timestamp-Serial-Crawlhost.warc.gz
+const DefaultFilenameTemplate = "{prefix}-{timestamp}-{serial:5}-{hostname}"
 
-// GenerateWarcFileName generate a WARC file name following recommendations
-// of the specs:
-// Prefix-Timestamp-Serial-Crawlhost.warc.gz
-func generateWarcFileName(prefix string, compression string, serial *atomic.Uint64) (fileName string) {
-	filenameGenerationLock.Lock()
-	defer filenameGenerationLock.Unlock()
+// filenameToken matches the tokens of a filename template, e.g. {serial:5}.
+// The tokens are:
+//
+//	{prefix}            RotatorSettings.Prefix
+//	{timestamp}         UTC time of creation of the file, as 20060102150405 followed by milliseconds
+//	{timestamp:LAYOUT}  UTC time of creation of the file, formatted with the Go time layout LAYOUT
+//	{serial}            serial number of the file, {serial:N} pads it with zeros to N digits
+//	{hostname}          host name as reported by the kernel
+//	{writer}            index of the writer of the rotator writing the file
+//	{random}            8 random hexadecimal characters
+var filenameToken = regexp.MustCompile(`\{(\w+)(?::([^}]*))?\}`)
 
+// checkFilenameTemplate returns an error if template has unknown or malformed tokens.
+func checkFilenameTemplate(template string) error {
+	if strings.ContainsAny(template, "/\\") {
+		return fmt.Errorf("invalid filename template %q: path separators aren't allowed", template)
+	}
+
+	for _, token := range filenameToken.FindAllStringSubmatch(template, -1) {
+		switch token[1] {
+		case "prefix", "hostname", "writer", "random":
+			if token[2] != "" {
+				return fmt.Errorf("invalid filename template %q: %s doesn't take an argument", template, token[0])
+			}
+		case "timestamp":
+		case "serial":
+			if token[2] != "" {
+				if width, err := strconv.Atoi(token[2]); err != nil || width < 1 {
+					return fmt.Errorf("invalid filename template %q: invalid serial width in %s", template, token[0])
+				}
+			}
+		default:
+			return fmt.Errorf("invalid filename template %q: unknown token %s", template, token[0])
+		}
+	}
+
+	if !strings.Contains(template, "{serial") && !strings.Contains(template, "{random}") {
+		return fmt.Errorf("invalid filename template %q: it needs a {serial} or {random} token to name files uniquely", template)
+	}
+
+	return nil
+}
+
+// generateWarcFileName generates the name of a new WARC file from the template
+// of the settings, with the .open suffix, taking the next serial.
+func generateWarcFileName(settings *RotatorSettings, serial *serialCounter, writerID int) (fileName string, err error) {
 	// Get host name as reported by the kernel
 	hostName, err := os.Hostname()
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+
+	serialNumber, err := serial.next()
+	if err != nil {
+		return "", err
 	}
 
-	// Don't let serial overflow past 99999, the current maximum with 5 serial digits.
-	serial.CompareAndSwap(99999, 0)
+	now := time.Now().UTC()
 
-	// Atomically increase the global serial number
-	serial.Add(1)
+	fileName = filenameToken.ReplaceAllStringFunc(settings.FilenameTemplate, func(token string) string {
+		match := filenameToken.FindStringSubmatch(token)
 
-	formattedSerial := formatSerial(serial, "5")
+		switch match[1] {
+		case "prefix":
+			return settings.Prefix
+		case "timestamp":
+			if match[2] != "" {
+				return now.Format(match[2])
+			}
 
-	now := time.Now().UTC()
-	date := now.Format("20060102150405") + strconv.Itoa(now.Nanosecond())[:3]
-
-	var fileExt string
-	if compression == "GZIP" {
-		fileExt = ".warc.gz.open"
-	} else if compression == "ZSTD" {
-		fileExt = ".warc.zst.open"
-	} else {
-		fileExt = ".warc.open"
-	}
+			return now.Format("20060102150405") + fmt.Sprintf("%03d", now.Nanosecond()/int(time.Millisecond))
+		case "serial":
+			return formatSerial(serialNumber, match[2])
+		case "hostname":
+			return hostName
+		case "writer":
+			return strconv.Itoa(writerID)
+		case "random":
+			randomBytes := make([]byte, 4)
+			rand.Read(randomBytes)
+			return hex.EncodeToString(randomBytes)
+		}
+
+		return token
+	})
 
-	return prefix + "-" + date + "-" + formattedSerial + "-" + hostName + fileExt
+	return fileName + CompressionAlgorithm(settings.Compression).Extension() + ".open", nil
 }
 
 // formatSerial add the correct padding to the serial
-// E.g. with serial = 23 and format = 5:
+// E.g. with serial = 23 and width = 5:
 // formatSerial return 00023
-func formatSerial(serial *atomic.Uint64, format string) string {
-	return fmt.Sprintf("%0"+format+"d", serial.Load())
+func formatSerial(serial uint64, width string) string {
+	if width == "" {
+		return strconv.FormatUint(serial, 10)
+	}
+
+	return fmt.Sprintf("%0"+width+"d", serial)
 }
 
-// isFielSizeExceeded compare the size of a file (filePath) with
-// a max size (maxSize), if the size of filePath exceed maxSize,
-// it returns true, else, it returns false
-func isFileSizeExceeded(file *os.File, maxSize float64) bool {
-	// Get actual file size
-	stat, err := file.Stat()
+// serialCounter numbers the WARC files of a rotator. Serials never wrap, and
+// when path is set the last one is persisted there so that a rotator started
+// again with the same settings keeps numbering forward.
+type serialCounter struct {
+	mutex sync.Mutex
+	value uint64
+	path  string
+}
+
+// newSerialCounter returns a serialCounter starting after the serial persisted at path, if any.
+func newSerialCounter(path string) (*serialCounter, error) {
+	counter := &serialCounter{path: path}
+
+	if path == "" {
+		return counter, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return counter, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	counter.value, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("invalid serial file %s: %w", path, err)
+	}
+
+	return counter, nil
+}
+
+// next returns the next serial, after persisting it.
+func (s *serialCounter) next() (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.value++
+
+	if s.path != "" {
+		// The serial is written to a temporary file renamed over the previous one, so that it is never left truncated
+		temporaryPath := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+
+		if err := os.WriteFile(temporaryPath, []byte(strconv.FormatUint(s.value, 10)+"\n"), 0644); err != nil {
+			return 0, err
+		}
+
+		if err := os.Rename(temporaryPath, s.path); err != nil {
+			return 0, err
+		}
 	}
-	fileSize := (float64)((stat.Size() / 1024) / 1024)
 
-	// If fileSize exceed maxSize, return true
-	return fileSize >= maxSize
+	return s.value, nil
+}
+
+// isFielSizeExceeded compare the size of a file (filePath) with
+// a max size (maxSize), if the size of filePath exceed maxSize,
+// it returns true, else, it returns false
+func isFileSizeExceeded(file StorageFile, maxSize int64) bool {
+	// If the file size exceed maxSize, return true
+	return file.Size() >= maxSize
 }
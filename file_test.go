@@ -0,0 +1,123 @@
+package warc
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerateWarcFileName(t *testing.T) {
+	hostName, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		template    string
+		compression string
+		expected    *regexp.Regexp
+	}{
+		{DefaultFilenameTemplate, "GZIP", regexp.MustCompile(`^TEST-\d{17}-00001-` + regexp.QuoteMeta(hostName) + `\.warc\.gz\.open$`)},
+		{"{prefix}_{timestamp:2006-01-02}_{serial}_w{writer}", "ZSTD", regexp.MustCompile(`^TEST_\d{4}-\d{2}-\d{2}_1_w3\.warc\.zst\.open$`)},
+		{"{serial:8}-{random}", "", regexp.MustCompile(`^00000001-[0-9a-f]{8}\.warc\.open$`)},
+	} {
+		settings := &RotatorSettings{Prefix: "TEST", FilenameTemplate: test.template, Compression: test.compression}
+
+		serial, err := newSerialCounter("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fileName, err := generateWarcFileName(settings, serial, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !test.expected.MatchString(fileName) {
+			t.Errorf("template %q: unexpected file name %q", test.template, fileName)
+		}
+	}
+}
+
+func TestCheckFilenameTemplate(t *testing.T) {
+	for template, valid := range map[string]bool{
+		DefaultFilenameTemplate:                  true,
+		"{prefix}-{timestamp:20060102}-{random}": true,
+		"{prefix}-{unknown}-{serial}":            false,
+		"{prefix}-{serial:abc}":                  false,
+		"{prefix}-{hostname:x}-{serial}":         false,
+		"{prefix}-{timestamp}":                   false,
+		"dir/{serial}":                           false,
+	} {
+		if err := checkFilenameTemplate(template); (err == nil) != valid {
+			t.Errorf("template %q: expected valid=%t, got %v", template, valid, err)
+		}
+	}
+}
+
+func TestSerialCounterPersistence(t *testing.T) {
+	serialFile := filepath.Join(t.TempDir(), "serial")
+
+	if err := os.WriteFile(serialFile, []byte("99999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := newSerialCounter(serialFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serials don't wrap at 99999 anymore
+	next, err := serial.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != 100000 || formatSerial(next, "5") != "100000" {
+		t.Errorf("expected serial 100000, got %d", next)
+	}
+
+	// A new counter resumes after the last serial
+	serial, err = newSerialCounter(serialFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next, err = serial.next(); err != nil || next != 100001 {
+		t.Errorf("expected serial 100001, got %d (%v)", next, err)
+	}
+}
+
+func TestRotatorSerialFile(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.FilenameTemplate = "{prefix}-{serial:5}"
+	rotatorSettings.SerialFile = filepath.Join(t.TempDir(), "serial")
+
+	// Two rotators run one after the other, like a crawl being resumed
+	for i := 0; i < 2; i++ {
+		records, doneChannels, err := rotatorSettings.NewWARCRotator()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestBatches(t, records, 1, 1, 10)
+		closeTestRotator(records, doneChannels)
+	}
+
+	for _, name := range []string{"TEST-00001.warc.gz", "TEST-00002.warc.gz"} {
+		if _, err := os.Stat(filepath.Join(rotatorSettings.OutputDirectory, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+
+	data, err := os.ReadFile(rotatorSettings.SerialFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(data)) != "2" {
+		t.Errorf("expected the serial file to hold 2, got %q", data)
+	}
+}
@@ -0,0 +1,311 @@
+package warc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives the measurements of the capture and writing pipeline of a
+// client and its rotator. Implement it to feed an existing metrics system, or
+// use CaptureMetrics, which can serve them in the Prometheus text format.
+// Methods are called concurrently and must not block.
+type Metrics interface {
+	// AddBytesWritten is called after a batch of records has been written to a WARC file by writer
+	AddBytesWritten(writer int, bytes int64)
+	// AddThrottledDuration is called after a batch of records has been written by writer
+	// with the time it spent waiting for the write rate limits of the rotator
+	AddThrottledDuration(writer int, d time.Duration)
+	// IncRecordsWritten is called for every record written, with its WARC-Type
+	IncRecordsWritten(recordType string)
+	// IncRevisits is called for every response written as a revisit record, with the
+	// deduplication that found it: "local", "cdx" or "conditional"
+	IncRevisits(kind string)
+	// IncDNSLookups is called for every hostname resolution, cached tells if it was answered by the cache
+	IncDNSLookups(cached bool)
+	// IncSpooledToDisk is called for every record written which content didn't fit in memory
+	IncSpooledToDisk()
+	// ObserveDialDuration is called with the time it took to establish a connection
+	ObserveDialDuration(d time.Duration)
+	// ObserveTLSHandshakeDuration is called with the time it took to complete a TLS handshake
+	ObserveTLSHandshakeDuration(d time.Duration)
+	// SetQueueDepth is called with the number of batches waiting for a writer of the rotator
+	SetQueueDepth(depth int)
+	// ObserveQueueWait is called when a writer takes a batch sent with SendBatch, with
+	// the time since it was sent, and the lane it was sent to: "default" or "priority"
+	ObserveQueueWait(lane string, d time.Duration)
+}
+
+// noopMetrics is used when no Metrics are configured.
+type noopMetrics struct{}
+
+func (noopMetrics) AddBytesWritten(int, int64)                {}
+func (noopMetrics) AddThrottledDuration(int, time.Duration)   {}
+func (noopMetrics) IncRecordsWritten(string)                  {}
+func (noopMetrics) IncRevisits(string)                        {}
+func (noopMetrics) IncDNSLookups(bool)                        {}
+func (noopMetrics) IncSpooledToDisk()                         {}
+func (noopMetrics) ObserveDialDuration(time.Duration)         {}
+func (noopMetrics) ObserveTLSHandshakeDuration(time.Duration) {}
+func (noopMetrics) SetQueueDepth(int)                         {}
+func (noopMetrics) ObserveQueueWait(string, time.Duration)    {}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets of
+// the dial and TLS handshake latency histograms of CaptureMetrics.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative histogram, as defined by Prometheus.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += value
+	h.count++
+}
+
+// CaptureMetrics is a Metrics implementation keeping the measurements in memory.
+// It is an http.Handler serving them in the Prometheus text exposition format.
+type CaptureMetrics struct {
+	mutex            sync.Mutex
+	bytesWritten     map[int]int64
+	throttled        map[int]time.Duration
+	recordsWritten   map[string]uint64
+	revisits         map[string]uint64
+	dnsHits          uint64
+	dnsMisses        uint64
+	spooledToDisk    uint64
+	queueDepth       int
+	queueWait        map[string]*histogram
+	dialDuration     *histogram
+	tlsHandshakeTime *histogram
+}
+
+// NewCaptureMetrics returns empty CaptureMetrics, to be set in HTTPClientSettings.Metrics.
+func NewCaptureMetrics() *CaptureMetrics {
+	return &CaptureMetrics{
+		bytesWritten:     make(map[int]int64),
+		throttled:        make(map[int]time.Duration),
+		recordsWritten:   make(map[string]uint64),
+		revisits:         make(map[string]uint64),
+		queueWait:        make(map[string]*histogram),
+		dialDuration:     newHistogram(DefaultLatencyBuckets),
+		tlsHandshakeTime: newHistogram(DefaultLatencyBuckets),
+	}
+}
+
+func (m *CaptureMetrics) AddBytesWritten(writer int, bytes int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.bytesWritten[writer] += bytes
+}
+
+func (m *CaptureMetrics) AddThrottledDuration(writer int, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.throttled[writer] += d
+}
+
+func (m *CaptureMetrics) IncRecordsWritten(recordType string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recordsWritten[recordType]++
+}
+
+func (m *CaptureMetrics) IncRevisits(kind string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.revisits[kind]++
+}
+
+func (m *CaptureMetrics) IncDNSLookups(cached bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if cached {
+		m.dnsHits++
+	} else {
+		m.dnsMisses++
+	}
+}
+
+func (m *CaptureMetrics) IncSpooledToDisk() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.spooledToDisk++
+}
+
+func (m *CaptureMetrics) ObserveDialDuration(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dialDuration.observe(d.Seconds())
+}
+
+func (m *CaptureMetrics) ObserveTLSHandshakeDuration(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tlsHandshakeTime.observe(d.Seconds())
+}
+
+func (m *CaptureMetrics) SetQueueDepth(depth int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queueDepth = depth
+}
+
+func (m *CaptureMetrics) ObserveQueueWait(lane string, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.queueWait[lane] == nil {
+		m.queueWait[lane] = newHistogram(DefaultLatencyBuckets)
+	}
+
+	m.queueWait[lane].observe(d.Seconds())
+}
+
+// DNSCacheHitRatio returns the fraction of hostname resolutions answered by the cache.
+func (m *CaptureMetrics) DNSCacheHitRatio() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.dnsCacheHitRatio()
+}
+
+func (m *CaptureMetrics) dnsCacheHitRatio() float64 {
+	if m.dnsHits+m.dnsMisses == 0 {
+		return 0
+	}
+
+	return float64(m.dnsHits) / float64(m.dnsHits+m.dnsMisses)
+}
+
+// WriteText writes the metrics to w in the Prometheus text exposition format.
+func (m *CaptureMetrics) WriteText(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var b strings.Builder
+
+	writeHeader := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	writeHeader("warc_bytes_written_total", "counter", "Bytes written to WARC files, per writer of the rotator.")
+	writers := make([]int, 0, len(m.bytesWritten))
+	for writer := range m.bytesWritten {
+		writers = append(writers, writer)
+	}
+	slices.Sort(writers)
+	for _, writer := range writers {
+		fmt.Fprintf(&b, "warc_bytes_written_total{writer=\"%d\"} %d\n", writer, m.bytesWritten[writer])
+	}
+
+	writeHeader("warc_writer_throttled_seconds_total", "counter", "Time spent waiting for the write rate limits, per writer of the rotator.")
+	writers = writers[:0]
+	for writer := range m.throttled {
+		writers = append(writers, writer)
+	}
+	slices.Sort(writers)
+	for _, writer := range writers {
+		fmt.Fprintf(&b, "warc_writer_throttled_seconds_total{writer=\"%d\"} %s\n", writer, formatFloat(m.throttled[writer].Seconds()))
+	}
+
+	writeHeader("warc_records_written_total", "counter", "Records written to WARC files, per WARC-Type.")
+	writeLabeledCounters(&b, "warc_records_written_total", "type", m.recordsWritten)
+
+	writeHeader("warc_revisits_total", "counter", "Responses written as revisit records, per kind of deduplication.")
+	writeLabeledCounters(&b, "warc_revisits_total", "kind", m.revisits)
+
+	writeHeader("warc_dns_lookups_total", "counter", "Hostname resolutions, per result of the DNS cache.")
+	fmt.Fprintf(&b, "warc_dns_lookups_total{cache=\"hit\"} %d\n", m.dnsHits)
+	fmt.Fprintf(&b, "warc_dns_lookups_total{cache=\"miss\"} %d\n", m.dnsMisses)
+
+	writeHeader("warc_dns_cache_hit_ratio", "gauge", "Fraction of hostname resolutions answered by the DNS cache.")
+	fmt.Fprintf(&b, "warc_dns_cache_hit_ratio %s\n", formatFloat(m.dnsCacheHitRatio()))
+
+	writeHeader("warc_spooled_to_disk_total", "counter", "Records which content was spooled to disk.")
+	fmt.Fprintf(&b, "warc_spooled_to_disk_total %d\n", m.spooledToDisk)
+
+	writeHeader("warc_dial_duration_seconds", "histogram", "Time to establish connections.")
+	writeHistogram(&b, "warc_dial_duration_seconds", "", m.dialDuration)
+
+	writeHeader("warc_tls_handshake_duration_seconds", "histogram", "Time to complete TLS handshakes.")
+	writeHistogram(&b, "warc_tls_handshake_duration_seconds", "", m.tlsHandshakeTime)
+
+	writeHeader("warc_writer_queue_depth", "gauge", "Batches of records waiting for a writer of the rotator.")
+	fmt.Fprintf(&b, "warc_writer_queue_depth %d\n", m.queueDepth)
+
+	writeHeader("warc_writer_queue_wait_seconds", "histogram", "Time batches waited to be taken by a writer of the rotator, per lane.")
+	lanes := make([]string, 0, len(m.queueWait))
+	for lane := range m.queueWait {
+		lanes = append(lanes, lane)
+	}
+	slices.Sort(lanes)
+	for _, lane := range lanes {
+		writeHistogram(&b, "warc_writer_queue_wait_seconds", "lane="+strconv.Quote(lane), m.queueWait[lane])
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *CaptureMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+func writeLabeledCounters(b *strings.Builder, name, label string, counters map[string]uint64) {
+	values := make([]string, 0, len(counters))
+	for value := range counters {
+		values = append(values, value)
+	}
+	slices.Sort(values)
+
+	for _, value := range values {
+		fmt.Fprintf(b, "%s{%s=%s} %d\n", name, label, strconv.Quote(value), counters[value])
+	}
+}
+
+// writeHistogram writes h, labels are added to its series when not empty.
+func writeHistogram(b *strings.Builder, name, labels string, h *histogram) {
+	bucketLabels, seriesLabels := "", ""
+	if labels != "" {
+		bucketLabels = labels + ","
+		seriesLabels = "{" + labels + "}"
+	}
+
+	for i, bound := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{%sle=\"%s\"} %d\n", name, bucketLabels, formatFloat(bound), h.counts[i])
+	}
+
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, bucketLabels, h.count)
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, seriesLabels, formatFloat(h.sum))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, seriesLabels, h.count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
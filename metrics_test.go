@@ -0,0 +1,148 @@
+package warc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureMetricsText(t *testing.T) {
+	metrics := NewCaptureMetrics()
+
+	metrics.AddBytesWritten(1, 100)
+	metrics.AddBytesWritten(0, 50)
+	metrics.AddBytesWritten(1, 20)
+	metrics.AddThrottledDuration(0, 1500*time.Millisecond)
+	metrics.IncRecordsWritten("response")
+	metrics.IncRecordsWritten("response")
+	metrics.IncRecordsWritten("revisit")
+	metrics.IncRevisits("local")
+	metrics.IncDNSLookups(true)
+	metrics.IncDNSLookups(true)
+	metrics.IncDNSLookups(true)
+	metrics.IncDNSLookups(false)
+	metrics.IncSpooledToDisk()
+	metrics.ObserveDialDuration(20 * time.Millisecond)
+	metrics.ObserveDialDuration(3 * time.Second)
+	metrics.SetQueueDepth(4)
+	metrics.ObserveQueueWait("priority", 30*time.Millisecond)
+
+	if metrics.DNSCacheHitRatio() != 0.75 {
+		t.Errorf("unexpected DNS cache hit ratio %f", metrics.DNSCacheHitRatio())
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type %q", recorder.Header().Get("Content-Type"))
+	}
+
+	text := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE warc_bytes_written_total counter",
+		`warc_bytes_written_total{writer="0"} 50`,
+		`warc_bytes_written_total{writer="1"} 120`,
+		`warc_writer_throttled_seconds_total{writer="0"} 1.5`,
+		`warc_records_written_total{type="response"} 2`,
+		`warc_records_written_total{type="revisit"} 1`,
+		`warc_revisits_total{kind="local"} 1`,
+		`warc_dns_lookups_total{cache="hit"} 3`,
+		`warc_dns_lookups_total{cache="miss"} 1`,
+		"warc_dns_cache_hit_ratio 0.75",
+		"warc_spooled_to_disk_total 1",
+		"# TYPE warc_dial_duration_seconds histogram",
+		`warc_dial_duration_seconds_bucket{le="0.01"} 0`,
+		`warc_dial_duration_seconds_bucket{le="0.025"} 1`,
+		`warc_dial_duration_seconds_bucket{le="5"} 2`,
+		`warc_dial_duration_seconds_bucket{le="+Inf"} 2`,
+		"warc_dial_duration_seconds_sum 3.02",
+		"warc_dial_duration_seconds_count 2",
+		"warc_tls_handshake_duration_seconds_count 0",
+		"warc_writer_queue_depth 4",
+		`warc_writer_queue_wait_seconds_bucket{lane="priority",le="0.025"} 0`,
+		`warc_writer_queue_wait_seconds_bucket{lane="priority",le="0.05"} 1`,
+		`warc_writer_queue_wait_seconds_count{lane="priority"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, text)
+		}
+	}
+}
+
+func TestHTTPClientMetrics(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		metrics         = NewCaptureMetrics()
+		errWg           sync.WaitGroup
+	)
+
+	fileBytes, err := os.ReadFile(path.Join("testdata", "image.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(fileBytes)
+	}))
+	defer server.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+		RotatorSettings: rotatorSettings,
+		DedupeOptions:   DedupeOptions{LocalDedupe: true},
+		Metrics:         metrics,
+	})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		resp, err := httpClient.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	httpClient.Close()
+	errWg.Wait()
+
+	// Two exchanges, one of them deduplicated, plus the warcinfo record which isn't counted
+	if metrics.recordsWritten["request"] != 2 || metrics.recordsWritten["response"] != 1 || metrics.recordsWritten["revisit"] != 1 {
+		t.Errorf("unexpected records written %v", metrics.recordsWritten)
+	}
+
+	if metrics.revisits["local"] != 1 {
+		t.Errorf("unexpected revisits %v", metrics.revisits)
+	}
+
+	if metrics.bytesWritten[0] <= 0 {
+		t.Errorf("unexpected bytes written %v", metrics.bytesWritten)
+	}
+
+	if metrics.dialDuration.count != 2 {
+		t.Errorf("expected 2 dial durations, got %d", metrics.dialDuration.count)
+	}
+
+	if rotatorSettings.Metrics != metrics {
+		t.Error("the rotator should report to the metrics of the client")
+	}
+}
@@ -0,0 +1,204 @@
+package warc
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// The WARC-Payload-Digest of a response record is computed over the payload of
+// the HTTP message it contains. Following the WARC 1.1 specification (section
+// 5.9) and its annotations, the payload is the entity-body of the message, that
+// is the message-body with any Transfer-Encoding undone (chunked, gzip, deflate)
+// but any Content-Encoding preserved. The status line and the HTTP headers are
+// not part of the payload, neither are chunk sizes or trailers.
+//
+// ReadHTTPResponse and GetPayloadDigest implement this definition, they are used
+// when writing records, when looking for duplicates and when verifying files.
+
+// ReadHTTPResponse reads an HTTP/1.x response from r, as stored in the block of
+// a response record. Unlike http.ReadResponse, it accepts any Transfer-Encoding
+// that SupportedContentEncodings or "chunked" can decode, and it skips interim
+// 1xx responses (except 101 Switching Protocols). The returned response's Body
+// is the payload of the message, as defined above.
+func ReadHTTPResponse(r *bufio.Reader) (resp *http.Response, err error) {
+	tp := textproto.NewReader(r)
+
+	for {
+		resp, err = readHTTPResponseHeader(tp)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+
+	body, err := newPayloadReader(r, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = body
+
+	return resp, nil
+}
+
+func readHTTPResponseHeader(tp *textproto.Reader) (*http.Response, error) {
+	line, err := tp.ReadLine()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf("malformed HTTP response %q", line)
+	}
+
+	resp := &http.Response{
+		Proto:  proto,
+		Status: strings.TrimLeft(status, " "),
+	}
+
+	statusCode, _, _ := strings.Cut(resp.Status, " ")
+	if len(statusCode) != 3 {
+		return nil, fmt.Errorf("malformed HTTP status code %q", statusCode)
+	}
+
+	resp.StatusCode, err = strconv.Atoi(statusCode)
+	if err != nil || resp.StatusCode < 0 {
+		return nil, fmt.Errorf("malformed HTTP status code %q", statusCode)
+	}
+
+	if resp.ProtoMajor, resp.ProtoMinor, ok = http.ParseHTTPVersion(resp.Proto); !ok {
+		return nil, fmt.Errorf("malformed HTTP version %q", resp.Proto)
+	}
+
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	resp.Header = http.Header(mimeHeader)
+	resp.ContentLength = -1
+
+	return resp, nil
+}
+
+// newPayloadReader returns a reader over the entity-body of resp, undoing the
+// transfer-codings listed in the Transfer-Encoding header, in reverse order.
+func newPayloadReader(r io.Reader, resp *http.Response) (io.ReadCloser, error) {
+	// These responses never have a body (RFC 9112, section 6.3)
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.ContentLength = 0
+		return http.NoBody, nil
+	}
+
+	var codings []string
+	for _, value := range resp.Header.Values("Transfer-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	// Without Transfer-Encoding, the body is delimited by Content-Length, or by the end of the message
+	if len(codings) == 0 {
+		if contentLength := resp.Header.Get("Content-Length"); contentLength != "" {
+			length, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("malformed Content-Length %q", contentLength)
+			}
+
+			resp.ContentLength = length
+			return io.NopCloser(io.LimitReader(r, length)), nil
+		}
+
+		return io.NopCloser(r), nil
+	}
+
+	resp.TransferEncoding = codings
+
+	body := io.NopCloser(r)
+	for i := len(codings) - 1; i >= 0; i-- {
+		switch {
+		case codings[i] == "chunked":
+			body = io.NopCloser(httputil.NewChunkedReader(body))
+		case isSupportedContentEncoding(codings[i]):
+			body = &contentDecoder{
+				coding: codings[i],
+				src:    body,
+			}
+		default:
+			return nil, fmt.Errorf("unsupported Transfer-Encoding %q", codings[i])
+		}
+	}
+
+	return body, nil
+}
+
+// ErrPayloadDigestUnverifiable is returned by GetPayloadDigest when the HTTP
+// headers show that the crawler which wrote the record stripped its
+// Transfer-Encoding or Content-Encoding (X-Crawler-Transfer-Encoding and
+// X-Crawler-Content-Encoding), so that the payload it hashed is lost.
+var ErrPayloadDigestUnverifiable = errors.New("stripped transfer or content encoding prevents computing the payload digest")
+
+// GetPayloadDigest returns the digest of the payload of the HTTP response
+// contained in block, formatted as a WARC-Payload-Digest value. Supported
+// algorithms are "sha1", encoded in base32, and "sha256", encoded in base16.
+func GetPayloadDigest(block io.Reader, algorithm string) (string, error) {
+	resp, err := ReadHTTPResponse(bufio.NewReader(block))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("X-Crawler-Transfer-Encoding") != "" || resp.Header.Get("X-Crawler-Content-Encoding") != "" {
+		return "", ErrPayloadDigestUnverifiable
+	}
+
+	return getDigest(resp.Body, algorithm)
+}
+
+func getDigest(r io.Reader, algorithm string) (string, error) {
+	var h hash.Hash
+
+	switch algorithm {
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return "", errors.New("unsupported digest algorithm: " + algorithm)
+	}
+
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	if algorithm == "sha256" {
+		return algorithm + ":" + hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	return algorithm + ":" + base32.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
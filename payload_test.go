@@ -0,0 +1,254 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chunkBody encodes data with the chunked transfer-coding, using chunks of at most size bytes.
+func chunkBody(data []byte, size int) string {
+	var chunked strings.Builder
+	for i := 0; i < len(data); i += size {
+		chunk := data[i:min(i+size, len(data))]
+		fmt.Fprintf(&chunked, "%x\r\n%s\r\n", len(chunk), chunk)
+	}
+	chunked.WriteString("0\r\n\r\n")
+
+	return chunked.String()
+}
+
+func TestReadHTTPResponse(t *testing.T) {
+	gzippedHello := encodeTestBody(t, "gzip", []byte("hello world"))
+
+	testCases := []struct {
+		name    string
+		message string
+		payload string
+	}{
+		{
+			name:    "content-length",
+			message: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhelloIGNORED",
+			payload: "hello",
+		},
+		{
+			name:    "read until EOF",
+			message: "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nhello world",
+			payload: "hello world",
+		},
+		{
+			name:    "chunked",
+			message: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nX-Trailer: foo\r\n\r\n",
+			payload: "hello world",
+		},
+		{
+			// The content-coding is part of the payload and must not be undone
+			name:    "chunked with content-encoding",
+			message: "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n" + chunkBody(gzippedHello, 8),
+			payload: string(gzippedHello),
+		},
+		{
+			// Every transfer-coding must be undone
+			name:    "transfer-encoding gzip",
+			message: "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n" + chunkBody(gzippedHello, 8),
+			payload: "hello world",
+		},
+		{
+			name:    "no content",
+			message: "HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n",
+			payload: "",
+		},
+		{
+			name:    "interim response",
+			message: "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+			payload: "hello",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := ReadHTTPResponse(bufio.NewReader(strings.NewReader(tc.message)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			payload, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(payload) != tc.payload {
+				t.Errorf("unexpected payload %q, expected %q", payload, tc.payload)
+			}
+		})
+	}
+}
+
+func TestReadHTTPResponseMalformed(t *testing.T) {
+	messages := []string{
+		"",
+		"HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"FOO/1.1 200 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: compress\r\n\r\n",
+	}
+
+	for _, message := range messages {
+		if _, err := ReadHTTPResponse(bufio.NewReader(strings.NewReader(message))); err == nil {
+			t.Errorf("expected an error for %q", message)
+		}
+	}
+}
+
+func TestGetPayloadDigest(t *testing.T) {
+	message := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+
+	digest, err := GetPayloadDigest(strings.NewReader(message), "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != "sha1:FKXGYNOJJ7H3IFO35FPUBC445EPOQRXN" {
+		t.Errorf("unexpected sha1 payload digest %s", digest)
+	}
+
+	digest, err = GetPayloadDigest(strings.NewReader(message), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Errorf("unexpected sha256 payload digest %s", digest)
+	}
+
+	if _, err = GetPayloadDigest(strings.NewReader(message), "md5"); err == nil {
+		t.Error("expected an error for an unsupported digest algorithm")
+	}
+}
+
+func TestGetPayloadDigestStrippedEncoding(t *testing.T) {
+	for _, header := range []string{"X-Crawler-Transfer-Encoding: chunked", "X-Crawler-Content-Encoding: gzip"} {
+		message := "HTTP/1.1 200 OK\r\n" + header + "\r\nContent-Length: 5\r\n\r\nhello"
+
+		if _, err := GetPayloadDigest(strings.NewReader(message), "sha1"); !errors.Is(err, ErrPayloadDigestUnverifiable) {
+			t.Errorf("%s: expected ErrPayloadDigestUnverifiable, got %v", header, err)
+		}
+	}
+}
+
+func TestHTTPClientPayloadDigestChunkedGzip(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+	)
+
+	fileBytes, err := os.ReadFile(path.Join("testdata", "image.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzippedBytes := encodeTestBody(t, "gzip", fileBytes)
+
+	// The payload is the gzip-compressed entity, without the chunked framing
+	expectedDigest, err := getDigest(bytes.NewReader(gzippedBytes), "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+
+		// Flushing forces the server to use chunked transfer-encoding
+		for i := 0; i < len(gzippedBytes); i += 1024 {
+			w.Write(gzippedBytes[i:min(i+1024, len(gzippedBytes))])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{RotatorSettings: rotatorSettings})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	resp, err := httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected a chunked response, got %v", resp.TransferEncoding)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	httpClient.Close()
+	errWg.Wait()
+
+	files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range files {
+		testFileSingleHashCheck(t, path, expectedDigest, []string{}, 1, server.URL+"/")
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if record.Header.Get("WARC-Type") == "response" {
+				digest, err := GetPayloadDigest(record.Content, "sha1")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if digest != record.Header.Get("WARC-Payload-Digest") {
+					t.Errorf("GetPayloadDigest doesn't match the written WARC-Payload-Digest: %s != %s", digest, record.Header.Get("WARC-Payload-Digest"))
+				}
+			}
+
+			record.Content.Close()
+		}
+
+		file.Close()
+	}
+}
@@ -9,30 +9,15 @@ import (
 	"time"
 )
 
-var (
-	IPv6 *availableIPs
-	IPv4 *availableIPs
-)
-
 type availableIPs struct {
 	IPs   atomic.Pointer[[]net.IPNet]
 	Index atomic.Uint64
 	AnyIP bool
 }
 
-func (c *CustomHTTPClient) getAvailableIPs(IPv6AnyIP bool) (IPs []net.IP, err error) {
+func (c *CustomHTTPClient) getAvailableIPs() (IPs []net.IP, err error) {
 	var first = true
 
-	if IPv6 == nil {
-		IPv6 = &availableIPs{
-			AnyIP: IPv6AnyIP,
-		}
-	}
-
-	if IPv4 == nil {
-		IPv4 = &availableIPs{}
-	}
-
 	for {
 		select {
 		case <-c.interfacesWatcherStop:
@@ -84,8 +69,8 @@ func (c *CustomHTTPClient) getAvailableIPs(IPv6AnyIP bool) (IPs []net.IP, err er
 			}
 
 			// Add the new addresses to the list
-			IPv6.IPs.Store(&newIPv6)
-			IPv4.IPs.Store(&newIPv4)
+			c.ipv6.IPs.Store(&newIPv6)
+			c.ipv4.IPs.Store(&newIPv4)
 
 			if first {
 				c.interfacesWatcherStarted <- true
@@ -122,19 +107,19 @@ func GetNextIP(availableIPs *availableIPs) net.IP {
 	return ipNet.IP
 }
 
-func getLocalAddr(network string, destIP net.IP) any {
+func (c *CustomHTTPClient) getLocalAddr(network string, destIP net.IP) any {
 	if destIP.To4() != nil {
 		if strings.Contains(network, "tcp") {
-			return &net.TCPAddr{IP: GetNextIP(IPv4)}
+			return &net.TCPAddr{IP: GetNextIP(c.ipv4)}
 		} else if strings.Contains(network, "udp") {
-			return &net.UDPAddr{IP: GetNextIP(IPv4)}
+			return &net.UDPAddr{IP: GetNextIP(c.ipv4)}
 		}
 		return nil
 	} else {
 		if strings.Contains(network, "tcp") {
-			return &net.TCPAddr{IP: GetNextIP(IPv6)}
+			return &net.TCPAddr{IP: GetNextIP(c.ipv6)}
 		} else if strings.Contains(network, "udp") {
-			return &net.UDPAddr{IP: GetNextIP(IPv6)}
+			return &net.UDPAddr{IP: GetNextIP(c.ipv6)}
 		}
 		return nil
 	}
@@ -204,13 +204,13 @@ func TestGetNextIPHighIndex(t *testing.T) {
 
 // TestGetLocalAddrIPv4TCP tests local address selection for IPv4 TCP connections.
 func TestGetLocalAddrIPv4TCP(t *testing.T) {
-	IPv4 = &availableIPs{}
+	c := &CustomHTTPClient{ipv4: &availableIPs{}}
 	ip1 := net.ParseIP("192.168.1.1")
 	ipNet1 := net.IPNet{IP: ip1, Mask: net.CIDRMask(24, 32)}
 	ipList := []net.IPNet{ipNet1}
-	IPv4.IPs.Store(&ipList)
+	c.ipv4.IPs.Store(&ipList)
 
-	addr := getLocalAddr("tcp", net.ParseIP("192.168.1.2"))
+	addr := c.getLocalAddr("tcp", net.ParseIP("192.168.1.2"))
 	tcpAddr, ok := addr.(*net.TCPAddr)
 	if !ok {
 		t.Errorf("Expected *net.TCPAddr, got %T", addr)
@@ -222,13 +222,13 @@ func TestGetLocalAddrIPv4TCP(t *testing.T) {
 
 // TestGetLocalAddrIPv6TCP tests local address selection for IPv6 TCP connections.
 func TestGetLocalAddrIPv6TCP(t *testing.T) {
-	IPv6 = &availableIPs{}
+	c := &CustomHTTPClient{ipv6: &availableIPs{}}
 	ip1 := net.ParseIP("2001:db8::1")
 	ipNet1 := net.IPNet{IP: ip1, Mask: net.CIDRMask(64, 128)}
 	ipList := []net.IPNet{ipNet1}
-	IPv6.IPs.Store(&ipList)
+	c.ipv6.IPs.Store(&ipList)
 
-	addr := getLocalAddr("tcp6", net.ParseIP("[2001:db8::2]"))
+	addr := c.getLocalAddr("tcp6", net.ParseIP("[2001:db8::2]"))
 	tcpAddr, ok := addr.(*net.TCPAddr)
 	if !ok {
 		t.Errorf("Expected *net.TCPAddr, got %T", addr)
@@ -239,13 +239,13 @@ func TestGetLocalAddrIPv6TCP(t *testing.T) {
 }
 
 func TestGetLocalAddrIPv6TCPAnyIP(t *testing.T) {
-	IPv6 = &availableIPs{AnyIP: true}
+	c := &CustomHTTPClient{ipv6: &availableIPs{AnyIP: true}}
 	ip1 := net.ParseIP("2001:db8::1")
 	ipNet1 := net.IPNet{IP: ip1, Mask: net.CIDRMask(64, 128)}
 	ipList := []net.IPNet{ipNet1}
-	IPv6.IPs.Store(&ipList)
+	c.ipv6.IPs.Store(&ipList)
 
-	addr := getLocalAddr("tcp6", net.ParseIP("[2001:db12::20]"))
+	addr := c.getLocalAddr("tcp6", net.ParseIP("[2001:db12::20]"))
 	tcpAddr, ok := addr.(*net.TCPAddr)
 	if !ok {
 		t.Errorf("Expected *net.TCPAddr, got %T", addr)
@@ -257,13 +257,13 @@ func TestGetLocalAddrIPv6TCPAnyIP(t *testing.T) {
 
 // TestGetLocalAddrIPv4UDP tests local address selection for IPv4 UDP connections.
 func TestGetLocalAddrIPv4UDP(t *testing.T) {
-	IPv4 = &availableIPs{}
+	c := &CustomHTTPClient{ipv4: &availableIPs{}}
 	ip1 := net.ParseIP("192.168.1.1")
 	ipNet1 := net.IPNet{IP: ip1, Mask: net.CIDRMask(24, 32)}
 	ipList := []net.IPNet{ipNet1}
-	IPv4.IPs.Store(&ipList)
+	c.ipv4.IPs.Store(&ipList)
 
-	addr := getLocalAddr("udp", net.ParseIP("192.168.1.2"))
+	addr := c.getLocalAddr("udp", net.ParseIP("192.168.1.2"))
 	udpAddr, ok := addr.(*net.UDPAddr)
 	if !ok {
 		t.Errorf("Expected *net.UDPAddr, got %T", addr)
@@ -275,13 +275,13 @@ func TestGetLocalAddrIPv4UDP(t *testing.T) {
 
 // TestGetLocalAddrIPv6UDP tests local address selection for IPv6 UDP connections.
 func TestGetLocalAddrIPv6UDP(t *testing.T) {
-	IPv6 = &availableIPs{}
+	c := &CustomHTTPClient{ipv6: &availableIPs{}}
 	ip1 := net.ParseIP("2001:db8::1")
 	ipNet1 := net.IPNet{IP: ip1, Mask: net.CIDRMask(64, 128)}
 	ipList := []net.IPNet{ipNet1}
-	IPv6.IPs.Store(&ipList)
+	c.ipv6.IPs.Store(&ipList)
 
-	addr := getLocalAddr("udp", net.ParseIP("[2001:db8::2]"))
+	addr := c.getLocalAddr("udp", net.ParseIP("[2001:db8::2]"))
 	udpAddr, ok := addr.(*net.UDPAddr)
 	if !ok {
 		t.Errorf("Expected *net.UDPAddr, got %T", addr)
@@ -293,7 +293,8 @@ func TestGetLocalAddrIPv6UDP(t *testing.T) {
 
 // TestGetLocalAddrUnknownNetwork tests the function with an unknown network type.
 func TestGetLocalAddrUnknownNetwork(t *testing.T) {
-	addr := getLocalAddr("unknown", net.ParseIP("192.168.1.2"))
+	c := &CustomHTTPClient{ipv4: &availableIPs{}}
+	addr := c.getLocalAddr("unknown", net.ParseIP("192.168.1.2"))
 	if addr != nil {
 		t.Errorf("Expected nil, got %v", addr)
 	}
@@ -301,14 +302,13 @@ func TestGetLocalAddrUnknownNetwork(t *testing.T) {
 
 // TestAnyIPIPv6IPv4DisabledRealLife tests the function with IPv6 enabled and IPv4 disabled.
 func TestAnyIPIPv6IPv4DisabledRealLife(t *testing.T) {
-	IPv6 = &availableIPs{AnyIP: true}
-	IPv4 = &availableIPs{}
+	c := &CustomHTTPClient{ipv4: &availableIPs{}, ipv6: &availableIPs{AnyIP: true}}
 	ip1 := net.ParseIP("2001:db8::1")
 	ipNet1 := net.IPNet{IP: ip1, Mask: net.CIDRMask(64, 128)}
 	ipList := []net.IPNet{ipNet1}
-	IPv6.IPs.Store(&ipList)
+	c.ipv6.IPs.Store(&ipList)
 
-	tcpAddr := getLocalAddr("tcp6", net.ParseIP("2606:4700:3030::ac43:a86a"))
+	tcpAddr := c.getLocalAddr("tcp6", net.ParseIP("2606:4700:3030::ac43:a86a"))
 	if tcpAddr == nil {
 		t.Error("Expected non-nil TCP address, got nil")
 	}
@@ -317,15 +317,7 @@ func TestAnyIPIPv6IPv4DisabledRealLife(t *testing.T) {
 
 // TestGetAvailableIPs is difficult due to its infinite loop and dependency on system interfaces.
 func TestGetAvailableIPsAnyIP(t *testing.T) {
-	if IPv6 == nil {
-		IPv6 = &availableIPs{
-			AnyIP: true,
-		}
-	}
-
-	if IPv4 == nil {
-		IPv4 = &availableIPs{}
-	}
+	c := &CustomHTTPClient{ipv4: &availableIPs{}, ipv6: &availableIPs{AnyIP: true}}
 
 	// Get all network interfaces
 	interfaces, err := net.Interfaces()
@@ -376,9 +368,9 @@ func TestGetAvailableIPsAnyIP(t *testing.T) {
 	}
 
 	// Add the new addresses to the list
-	IPv6.IPs.Store(&newIPv6)
-	IPv4.IPs.Store(&newIPv4)
+	c.ipv6.IPs.Store(&newIPv6)
+	c.ipv4.IPs.Store(&newIPv4)
 
-	tcpv6Addr := getLocalAddr("tcp", net.ParseIP("[2001:db8::2]:80"))
+	tcpv6Addr := c.getLocalAddr("tcp", net.ParseIP("[2001:db8::2]:80"))
 	t.Logf("IPv6 TCP address: %v", tcpv6Addr)
 }
@@ -3,6 +3,7 @@ package warc
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -16,6 +17,12 @@ type Reader struct {
 	bufReader *bufio.Reader
 	record    *Record
 	threshold int
+	// pending are the records read ahead by ReadReassembledRecord
+	pending []*Record
+	// file is the file being read and paths the ones left to read, when the
+	// reader was created by NewMultiFileReader
+	file  *os.File
+	paths []string
 }
 
 type reader interface {
@@ -44,6 +51,70 @@ func NewReader(reader io.ReadCloser) (*Reader, error) {
 	}, nil
 }
 
+// NewMultiFileReader returns a WARC reader reading the files at paths one after
+// the other, as if they were a single file. Given the files written by a
+// rotator in order, ReadReassembledRecord reassembles the records which
+// segments were written to several files, see RotatorSettings.SegmentSize.
+// The files are closed once read, or by Close.
+func NewMultiFileReader(paths []string) (*Reader, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no WARC file to read")
+	}
+
+	file, err := os.Open(paths[0])
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reader.file = file
+	reader.paths = paths[1:]
+
+	return reader, nil
+}
+
+// Close closes the file being read by a reader created by NewMultiFileReader,
+// it does nothing for the other readers.
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+// nextFile closes the file being read and starts reading the next one of paths.
+func (r *Reader) nextFile() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	file, err := os.Open(r.paths[0])
+	if err != nil {
+		return err
+	}
+
+	decReader, err := NewDecompressionReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.bufReader = bufio.NewReader(decReader)
+	r.file = file
+	r.paths = r.paths[1:]
+
+	return nil
+}
+
 func readUntilDelim(r reader, delim []byte) (line []byte, err error) {
 	for {
 		var s []byte
@@ -77,6 +148,17 @@ func (r *Reader) ReadRecord() (*Record, bool, error) {
 	warcVer, err = readUntilDelim(tempReader, []byte("\r\n"))
 	if err != nil {
 		if err == io.EOF {
+			// With NewMultiFileReader, the records continue in the next file
+			if len(r.paths) > 0 {
+				if err := r.nextFile(); err != nil {
+					return nil, false, err
+				}
+
+				return r.ReadRecord()
+			}
+
+			r.Close()
+
 			return nil, true, nil // EOF, no error
 		}
 		return nil, false, fmt.Errorf("reading WARC version: %w", err)
@@ -84,6 +166,7 @@ func (r *Reader) ReadRecord() (*Record, bool, error) {
 
 	// Parse the record headers
 	header := NewHeader()
+	var rawHeader []string
 	for {
 		line, err := readUntilDelim(tempReader, []byte("\r\n"))
 		if err != nil {
@@ -92,6 +175,7 @@ func (r *Reader) ReadRecord() (*Record, bool, error) {
 		if len(line) == 0 {
 			break
 		}
+		rawHeader = append(rawHeader, string(line))
 		if key, value := splitKeyValue(string(line)); key != "" {
 			header.Set(key, value)
 		}
@@ -111,9 +195,10 @@ func (r *Reader) ReadRecord() (*Record, bool, error) {
 	}
 
 	r.record = &Record{
-		Header:  header,
-		Content: buf,
-		Version: string(warcVer),
+		Header:    header,
+		Content:   buf,
+		Version:   string(warcVer),
+		rawHeader: rawHeader,
 	}
 
 	// Skip two empty lines
@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -149,7 +148,7 @@ func testFileSingleHashCheck(t *testing.T, path string, hash string, expectedCon
 				t.Fatal("failed to seek record content", "recordID", record.Header.Get("WARC-Record-ID"), "err", err.Error())
 			}
 
-			resp, err := http.ReadResponse(bufio.NewReader(record.Content), nil)
+			resp, err := ReadHTTPResponse(bufio.NewReader(record.Content))
 			if err != nil {
 				t.Fatal("failed to seek record content", "recordID", record.Header.Get("WARC-Record-ID"), "err", err.Error())
 			}
@@ -0,0 +1,228 @@
+package warc
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RecompressOptions tells how RecompressWARCFile compresses the new file.
+type RecompressOptions struct {
+	// Compression is the name of the CompressionAlgorithm of the new file
+	Compression string
+	// CompressionLevel is the level of the algorithm, 0 meaning its default level
+	CompressionLevel int
+	// Dictionary is the ZStd dictionary to compress with, embedded in the new file
+	Dictionary []byte
+}
+
+// RecompressWARCFile rewrites the WARC file at src to dst with another
+// compression, every record being compressed on its own. The records are read
+// with Reader and copied byte for byte, their header lines included, except the
+// WARC-Filename of the warcinfo records which is set to the name of dst. Their
+// block digests, and the payload digests of the response records, are verified
+// along the way. dst is written with the .open suffix and only renamed once
+// complete. It returns the number of records copied.
+func RecompressWARCFile(src, dst string, options RecompressOptions) (records int, err error) {
+	compression, err := ParseCompressionAlgorithm(options.Compression)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(options.Dictionary) > 0 && compression != CompressionZSTD {
+		return 0, errors.New("a dictionary can only be used with ZSTD")
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+
+	reader, err := NewReader(srcFile)
+	if err != nil {
+		return 0, err
+	}
+
+	dstFile, err := os.OpenFile(dst+".open", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			dstFile.Close()
+			os.Remove(dst + ".open")
+		}
+	}()
+
+	var (
+		output   = bufio.NewWriter(dstFile)
+		fileName = filepath.Base(dst)
+	)
+
+	// The same encoder is used for the whole file, reset after every record so
+	// that each one is in its own member or frame. The dictionary is written
+	// once, at the start of the file.
+	writer, err := NewWriterLevel(output, fileName, compression, options.CompressionLevel, true, options.Dictionary)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			break
+		}
+		if err != nil {
+			return records, fmt.Errorf("record %d: %w", records+1, err)
+		}
+
+		if err := recompressRecord(writer, record, records > 0); err != nil {
+			return records, fmt.Errorf("record %d: %w", records+1, err)
+		}
+
+		records++
+	}
+
+	if err := output.Flush(); err != nil {
+		return records, err
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return records, err
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		return records, fmt.Errorf("unable to rename %s: %s already exists", dst+".open", dst)
+	}
+
+	return records, os.Rename(dst+".open", dst)
+}
+
+// recompressRecord verifies the digests of record and copies it verbatim with
+// writer, resetting its encoder first if a record was written before. The
+// WARC-Filename of warcinfo records is set to the name of the file of writer.
+func recompressRecord(writer *Writer, record *Record, reset bool) error {
+	defer record.Content.Close()
+
+	if err := verifyRecordDigests(record); err != nil {
+		return err
+	}
+
+	if reset {
+		if err := writer.resetCompressedWriter(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(writer.FileWriter, record.Version+"\r\n"); err != nil {
+		return err
+	}
+
+	// The header is copied as it was read, repeated fields and their order included
+	for _, line := range record.rawHeader {
+		if key, _ := splitKeyValue(line); record.Header.Get("WARC-Type") == "warcinfo" && strings.EqualFold(key, "WARC-Filename") {
+			line = key + ": " + writer.FileName
+		}
+
+		if _, err := io.WriteString(writer.FileWriter, line+"\r\n"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(writer.FileWriter, "\r\n"); err != nil {
+		return err
+	}
+
+	record.Content.Seek(0, 0)
+	if _, err := io.Copy(writer.FileWriter, record.Content); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(writer.FileWriter, "\r\n\r\n"); err != nil {
+		return err
+	}
+
+	if err := writer.FileWriter.Flush(); err != nil {
+		return err
+	}
+
+	return writer.CloseCompressedWriter()
+}
+
+// verifyRecordDigests checks the WARC-Block-Digest of record and, for the
+// responses which aren't segmented, its WARC-Payload-Digest. Missing digests and
+// unsupported algorithms aren't errors, nor are payloads whose encoding was
+// stripped by the crawler, see ErrPayloadDigestUnverifiable.
+func verifyRecordDigests(record *Record) error {
+	blockDigest := record.Header.Get("WARC-Block-Digest")
+	digest, expected := newBlockDigest(blockDigest)
+
+	record.Content.Seek(0, 0)
+	if _, err := io.Copy(digest, record.Content); err != nil {
+		return fmt.Errorf("reading the record block: %w", err)
+	}
+
+	if expected != nil && !expected(digest.Sum(nil)) {
+		return fmt.Errorf("WARC-Block-Digest mismatch, expected %s", blockDigest)
+	}
+
+	payloadDigest := record.Header.Get("WARC-Payload-Digest")
+	if payloadDigest == "" || record.Header.Get("WARC-Type") != "response" || record.Header.Get("WARC-Segment-Number") != "" ||
+		!strings.HasPrefix(record.Header.Get("Content-Type"), "application/http") {
+		return nil
+	}
+
+	algorithm, _, _ := strings.Cut(payloadDigest, ":")
+	algorithm = strings.ToLower(algorithm)
+	if algorithm != "sha1" && algorithm != "sha256" {
+		return nil
+	}
+
+	record.Content.Seek(0, 0)
+	defer record.Content.Seek(0, 0)
+
+	computed, err := GetPayloadDigest(record.Content, algorithm)
+	if errors.Is(err, ErrPayloadDigestUnverifiable) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("computing the payload digest: %w", err)
+	}
+
+	if !strings.EqualFold(computed, payloadDigest) {
+		return fmt.Errorf("WARC-Payload-Digest mismatch, expected %s", payloadDigest)
+	}
+
+	return nil
+}
+
+// newBlockDigest returns the hash computing a WARC-Block-Digest of the form
+// algorithm:value, and a function telling if a sum matches it. The function is
+// nil when the digest is missing or its algorithm isn't supported.
+func newBlockDigest(blockDigest string) (hash.Hash, func([]byte) bool) {
+	algorithm, value, _ := strings.Cut(blockDigest, ":")
+
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New(), func(sum []byte) bool {
+			return strings.EqualFold(base32.StdEncoding.EncodeToString(sum), value)
+		}
+	case "sha256":
+		// Both the base32 and the base16 forms are in use
+		return sha256.New(), func(sum []byte) bool {
+			return strings.EqualFold(base32.StdEncoding.EncodeToString(sum), value) || strings.EqualFold(hex.EncodeToString(sum), value)
+		}
+	default:
+		return sha1.New(), nil
+	}
+}
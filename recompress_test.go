@@ -0,0 +1,233 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func decompressedFile(t *testing.T, path string) []byte {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewDecompressionReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// readTestRecords returns the headers and the contents of the records of the WARC file at path.
+func readTestRecords(t *testing.T, path string) (headers []Header, contents [][]byte) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			return headers, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(record.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Content.Close()
+
+		headers = append(headers, record.Header)
+		contents = append(contents, content)
+	}
+}
+
+func TestRecompressWARCFile(t *testing.T) {
+	src := writeDictionaryTestWARC(t)[0]
+
+	dictionary, err := TrainZStdDictionary([]string{src}, DictionaryOptions{MaxSize: 8 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GZIP to ZSTD with a dictionary, then back to GZIP under the original name
+	zstdPath := filepath.Join(t.TempDir(), strings.TrimSuffix(filepath.Base(src), ".warc.gz")+".warc.zst")
+	records, err := RecompressWARCFile(src, zstdPath, RecompressOptions{Compression: "zstd", CompressionLevel: 3, Dictionary: dictionary})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if records != 201 {
+		t.Errorf("expected 201 records, got %d", records)
+	}
+
+	zstdFile, err := os.Open(zstdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zstdFile.Close()
+
+	// One frame per record
+	if _, frames := lastCompleteZStdFrame(zstdFile); frames != 201 {
+		t.Errorf("expected 201 ZStd frames, got %d", frames)
+	}
+
+	if !bytes.Contains(decompressedFile(t, zstdPath), []byte("WARC-Filename: "+filepath.Base(zstdPath)+"\r\n")) {
+		t.Error("the WARC-Filename of the warcinfo record wasn't updated")
+	}
+
+	gzipPath := filepath.Join(t.TempDir(), filepath.Base(src))
+	if _, err := RecompressWARCFile(zstdPath, gzipPath, RecompressOptions{Compression: "GZIP"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decompressedFile(t, src), decompressedFile(t, gzipPath)) {
+		t.Error("the records weren't preserved byte for byte")
+	}
+
+	// XZ has no encoder to reset, a new one is created for every record
+	xzPath := filepath.Join(t.TempDir(), strings.TrimSuffix(filepath.Base(src), ".warc.gz")+".warc.xz")
+	if _, err := RecompressWARCFile(gzipPath, xzPath, RecompressOptions{Compression: "xz"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the WARC-Filename of the warcinfo record differs
+	expected := bytes.Replace(decompressedFile(t, src), []byte("WARC-Filename: "+filepath.Base(src)+"\r\n"), []byte("WARC-Filename: "+filepath.Base(xzPath)+"\r\n"), 1)
+	if !bytes.Equal(expected, decompressedFile(t, xzPath)) {
+		t.Error("the records weren't preserved byte for byte with XZ")
+	}
+
+	if counts := countRecordsPerFile(t, filepath.Dir(gzipPath)); len(counts) != 1 || counts[0] != 200 {
+		t.Errorf("expected 1 file of 200 records, got %v", counts)
+	}
+}
+
+func TestRecompressWARCFileVerbatim(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "verbatim.warc")
+
+	// Repeated fields, an unusual order and values Writer.WriteRecord would
+	// rewrite are all kept
+	records := "WARC/1.0\r\n" +
+		"WARC-Type: metadata\r\n" +
+		"WARC-Concurrent-To: <urn:uuid:00000000-0000-0000-0000-000000000001>\r\n" +
+		"WARC-Date: 2024-03-01T11:30:45.123456Z\r\n" +
+		"WARC-Concurrent-To: <urn:uuid:00000000-0000-0000-0000-000000000002>\r\n" +
+		"WARC-Profile: http://netpreserve.org/warc/1.1/revisit/identical-payload-digest\r\n" +
+		"Content-Length: 4\r\n" +
+		"\r\n" +
+		"data\r\n\r\n" +
+		"WARC/1.1\r\n" +
+		"WARC-Type: warcinfo\r\n" +
+		"WARC-Filename: verbatim.warc\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n" +
+		"\r\n\r\n"
+
+	if err := os.WriteFile(src, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "verbatim.warc.zst")
+	if count, err := RecompressWARCFile(src, dst, RecompressOptions{Compression: "zstd"}); err != nil || count != 2 {
+		t.Fatalf("expected 2 records, got %d (%v)", count, err)
+	}
+
+	expected := strings.Replace(records, "WARC-Filename: verbatim.warc\r\n", "WARC-Filename: verbatim.warc.zst\r\n", 1)
+	if recompressed := decompressedFile(t, dst); string(recompressed) != expected {
+		t.Errorf("the records weren't copied verbatim, got %q", recompressed)
+	}
+}
+
+func TestRecompressWARCFileDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bad.warc")
+
+	record := "WARC/1.1\r\nWARC-Type: resource\r\nWARC-Block-Digest: sha1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\r\nContent-Length: 4\r\n\r\ndata\r\n\r\n"
+	if err := os.WriteFile(src, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "bad.warc.gz")
+	if _, err := RecompressWARCFile(src, dst, RecompressOptions{Compression: "gzip"}); err == nil || !strings.Contains(err.Error(), "WARC-Block-Digest mismatch") {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+
+	for _, path := range []string{dst, dst + ".open"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to exist: %v", path, err)
+		}
+	}
+}
+
+func TestRecompressWARCFilePayloadDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bad.warc")
+
+	block := "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\ndata"
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "response")
+	record.Header.Set("Content-Type", "application/http; msgtype=response")
+	record.Header.Set("WARC-Payload-Digest", "sha1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	record.Content.Write([]byte(block))
+
+	var buf bytes.Buffer
+
+	writer, err := NewWriter(&buf, "bad.warc", "", "", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := writer.WriteRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "bad.warc.gz")
+	if _, err := RecompressWARCFile(src, dst, RecompressOptions{Compression: "gzip"}); err == nil || !strings.Contains(err.Error(), "WARC-Payload-Digest mismatch") {
+		t.Fatalf("expected a payload digest mismatch, got %v", err)
+	}
+
+	// With the right payload digest, the record is copied
+	record = NewRecord("", false)
+	record.Header.Set("WARC-Type", "response")
+	record.Header.Set("Content-Type", "application/http; msgtype=response")
+	record.Header.Set("WARC-Payload-Digest", "sha1:"+GetSHA1(strings.NewReader("data")))
+	record.Content.Write([]byte(block))
+
+	buf.Reset()
+	if _, err := writer.WriteRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err := RecompressWARCFile(src, dst, RecompressOptions{Compression: "xz"}); err != nil || records != 1 {
+		t.Fatalf("expected 1 record, got %d (%v)", records, err)
+	}
+}
@@ -1,6 +1,7 @@
 package warc
 
 import (
+	"context"
 	"io"
 )
 
@@ -32,7 +33,12 @@ func (c *CustomHTTPClient) WriteRecord(WARCTargetURI, WARCType, contentType, pay
 	batch := NewRecordBatch(make(chan struct{}, 1))
 	batch.Records = append(batch.Records, metadataRecord)
 
-	c.WARCWriter <- batch
+	// WriteRecord is called while dialing, e.g. for DNS records, which can't wait for a file
+	// to be closed with SyncOnClose. The other policies sync before signalling as usual
+	batch.feedbackOnWrite = true
+
+	// Small records like DNS records don't wait behind large exchanges when the rotator has a priority lane
+	c.rotatorSettings.SendBatch(context.Background(), batch, true)
 
 	// Wait for the record to be written
 	<-batch.FeedbackChan
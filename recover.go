@@ -0,0 +1,418 @@
+package warc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// A writer that crashed leaves its WARC file with the .open suffix, possibly
+// ending with a partially written record. Recovering such a file truncates it
+// after its last complete record (for compressed files, its last complete GZIP
+// member or ZStd frame, as every record is compressed on its own), optionally
+// appends a metadata record describing the recovery, and renames it to remove
+// the .open suffix.
+
+// RecoveredFile describes a WARC file recovered by RecoverWARCFile.
+type RecoveredFile struct {
+	// OpenPath is the path of the file before its recovery, with the .open suffix
+	OpenPath string
+	// Path is the final path of the file, empty if it was removed
+	Path string
+	// Records is the number of complete records kept, warcinfo included
+	Records int
+	// TruncatedBytes is the number of bytes removed from the end of the file
+	TruncatedBytes int64
+	// Removed is true when the file had no complete record and was deleted
+	Removed bool
+}
+
+// RecoverWARCFiles recovers every .open WARC file of dir, see RecoverWARCFile.
+// It must not be called while a rotator is writing to dir.
+func RecoverWARCFiles(dir string, appendMetadata bool) (recovered []RecoveredFile, err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.warc*.open"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		file, err := RecoverWARCFile(path, appendMetadata)
+		if err != nil {
+			return recovered, err
+		}
+
+		recovered = append(recovered, file)
+	}
+
+	return recovered, nil
+}
+
+// RecoverWARCFile truncates the .open WARC file at path after its last complete
+// record and renames it to remove the .open suffix. If appendMetadata is true,
+// a metadata record telling how many bytes were truncated is appended first.
+// A file without any complete record is removed.
+func RecoverWARCFile(path string, appendMetadata bool) (recovered RecoveredFile, err error) {
+	recovered.OpenPath = path
+
+	if !strings.HasSuffix(path, ".open") {
+		return recovered, fmt.Errorf("%s isn't an .open WARC file", path)
+	}
+
+	compression := ""
+	if strings.HasSuffix(path, ".gz.open") {
+		compression = "GZIP"
+	} else if strings.HasSuffix(path, ".zst.open") {
+		compression = "ZSTD"
+	} else if strings.HasSuffix(path, ".xz.open") {
+		return recovered, fmt.Errorf("unable to recover %s: recovering XZ WARC files isn't supported", path)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return recovered, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return recovered, err
+	}
+
+	var validSize int64
+
+	switch compression {
+	case "GZIP":
+		validSize, recovered.Records = lastCompleteGZIPMember(file)
+	case "ZSTD":
+		validSize, recovered.Records = lastCompleteZStdFrame(file)
+	default:
+		validSize, recovered.Records = lastCompleteRecord(file)
+	}
+
+	if recovered.Records == 0 {
+		if err := file.Close(); err != nil {
+			return recovered, err
+		}
+
+		recovered.Removed = true
+
+		return recovered, os.Remove(path)
+	}
+
+	recovered.TruncatedBytes = stat.Size() - validSize
+
+	if err := file.Truncate(validSize); err != nil {
+		return recovered, err
+	}
+
+	if appendMetadata {
+		if err := appendRecoveryRecord(file, path, compression, recovered.TruncatedBytes); err != nil {
+			return recovered, err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return recovered, err
+	}
+
+	recovered.Path = strings.TrimSuffix(path, ".open")
+	if _, err := os.Stat(recovered.Path); err == nil {
+		return recovered, fmt.Errorf("unable to rename %s: %s already exists", path, recovered.Path)
+	}
+
+	return recovered, os.Rename(path, recovered.Path)
+}
+
+// appendRecoveryRecord appends a metadata record describing the recovery of the
+// file, referring to the warcinfo record of the file.
+func appendRecoveryRecord(file *os.File, path, compression string, truncatedBytes int64) error {
+	var dictionary []byte
+
+	if compression == "ZSTD" {
+		var magic [4]byte
+		if _, err := file.ReadAt(magic[:], 0); err != nil {
+			return err
+		}
+
+		if string(magic[1:4]) == magicZStdSkippableFrame && magic[0]&0xf0 == 0x50 {
+			var err error
+
+			dictionary, err = readZStdCustomDict(io.NewSectionReader(file, 0, 1<<62))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader, err := NewReader(io.NopCloser(io.NewSectionReader(file, 0, stat.Size())))
+	if err != nil {
+		return err
+	}
+
+	warcinfo, _, err := reader.ReadRecord()
+	if err != nil {
+		return fmt.Errorf("reading the warcinfo record: %w", err)
+	}
+	warcinfo.Content.Close()
+
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "metadata")
+	record.Header.Set("Content-Type", "application/warc-fields")
+	if warcinfo.Header.Get("WARC-Type") == "warcinfo" {
+		record.Header.Set("WARC-Warcinfo-ID", warcinfo.Header.Get("WARC-Record-ID"))
+	}
+
+	fmt.Fprintf(record.Content, "recovered: %s\r\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(record.Content, "truncated-bytes: %d\r\n", truncatedBytes)
+
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	writer, err := NewWriter(file, strings.TrimSuffix(filepath.Base(path), ".open"), compression, "", false, dictionary)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.WriteRecord(record); err != nil {
+		return err
+	}
+
+	if compression != "" {
+		return writer.CloseCompressedWriter()
+	}
+
+	return nil
+}
+
+// countingReader counts the bytes read from a bufio.Reader, it is an io.ByteReader
+// so that decompressors don't read past the end of their stream.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// lastCompleteGZIPMember returns the offset of the end of the last GZIP member
+// of r that can be fully decompressed, and the number of members before it.
+func lastCompleteGZIPMember(r io.Reader) (validSize int64, members int) {
+	var (
+		counter    = &countingReader{r: bufio.NewReader(r)}
+		gzipReader *gzip.Reader
+		err        error
+	)
+
+	for {
+		if _, err := counter.r.Peek(1); err != nil {
+			return validSize, members
+		}
+
+		if gzipReader == nil {
+			gzipReader, err = gzip.NewReader(counter)
+		} else {
+			err = gzipReader.Reset(counter)
+		}
+		if err != nil {
+			return validSize, members
+		}
+
+		gzipReader.Multistream(false)
+
+		if _, err := io.Copy(io.Discard, gzipReader); err != nil {
+			return validSize, members
+		}
+
+		validSize = counter.n
+		members++
+	}
+}
+
+// lastCompleteZStdFrame returns the offset of the end of the last complete ZStd
+// frame of r, and the number of frames before it, skippable frames excluded.
+// The frames are only checked structurally, they aren't decompressed.
+func lastCompleteZStdFrame(r io.Reader) (validSize int64, frames int) {
+	counter := &countingReader{r: bufio.NewReader(r)}
+
+	for {
+		var magic [4]byte
+		if _, err := io.ReadFull(counter, magic[:]); err != nil {
+			return validSize, frames
+		}
+
+		switch {
+		case string(magic[1:4]) == magicZStdSkippableFrame && magic[0]&0xf0 == 0x50:
+			var size [4]byte
+			if _, err := io.ReadFull(counter, size[:]); err != nil {
+				return validSize, frames
+			}
+
+			if _, err := counter.r.Discard(int(binary.LittleEndian.Uint32(size[:]))); err != nil {
+				return validSize, frames
+			}
+			counter.n += int64(binary.LittleEndian.Uint32(size[:]))
+		case string(magic[:]) == magicZStdFrame:
+			if err := skipZStdFrame(counter); err != nil {
+				return validSize, frames
+			}
+			frames++
+		default:
+			return validSize, frames
+		}
+
+		validSize = counter.n
+	}
+}
+
+// skipZStdFrame reads the ZStd frame following its magic number (RFC 8878, section 3.1.1).
+func skipZStdFrame(counter *countingReader) error {
+	descriptor, err := counter.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	var (
+		singleSegment = descriptor&0x20 != 0
+		checksum      = descriptor&0x04 != 0
+		headerSize    = []int{0, 1, 2, 4}[descriptor&0x03]
+	)
+
+	if !singleSegment {
+		// Window descriptor
+		headerSize++
+	}
+
+	switch descriptor >> 6 {
+	case 0:
+		if singleSegment {
+			headerSize++
+		}
+	case 1:
+		headerSize += 2
+	case 2:
+		headerSize += 4
+	case 3:
+		headerSize += 8
+	}
+
+	if err := discardCounted(counter, headerSize); err != nil {
+		return err
+	}
+
+	for {
+		var blockHeader [4]byte
+		if _, err := io.ReadFull(counter, blockHeader[:3]); err != nil {
+			return err
+		}
+
+		var (
+			header    = binary.LittleEndian.Uint32(blockHeader[:])
+			lastBlock = header&1 != 0
+			blockSize = int(header >> 3)
+		)
+
+		switch (header >> 1) & 0x03 {
+		case 1:
+			// RLE blocks hold a single byte
+			blockSize = 1
+		case 3:
+			return errors.New("reserved ZStd block type")
+		}
+
+		if err := discardCounted(counter, blockSize); err != nil {
+			return err
+		}
+
+		if lastBlock {
+			break
+		}
+	}
+
+	if checksum {
+		return discardCounted(counter, 4)
+	}
+
+	return nil
+}
+
+func discardCounted(counter *countingReader, n int) error {
+	discarded, err := counter.r.Discard(n)
+	counter.n += int64(discarded)
+	return err
+}
+
+// lastCompleteRecord returns the offset of the end of the last complete record
+// of the uncompressed WARC file r, and the number of records before it.
+func lastCompleteRecord(r io.Reader) (validSize int64, records int) {
+	counter := &countingReader{r: bufio.NewReader(r)}
+
+	for {
+		version, err := counter.r.ReadString('\n')
+		counter.n += int64(len(version))
+		if err != nil || !strings.HasPrefix(version, "WARC/") {
+			return validSize, records
+		}
+
+		contentLength := -1
+		for {
+			line, err := counter.r.ReadString('\n')
+			counter.n += int64(len(line))
+			if err != nil {
+				return validSize, records
+			}
+
+			if line == "\r\n" {
+				break
+			}
+
+			if key, value := splitKeyValue(strings.TrimSuffix(line, "\r\n")); strings.EqualFold(key, "Content-Length") {
+				contentLength, err = strconv.Atoi(value)
+				if err != nil {
+					return validSize, records
+				}
+			}
+		}
+
+		if contentLength < 0 {
+			return validSize, records
+		}
+
+		if err := discardCounted(counter, contentLength); err != nil {
+			return validSize, records
+		}
+
+		var boundary [4]byte
+		if _, err := io.ReadFull(counter, boundary[:]); err != nil || string(boundary[:]) != "\r\n\r\n" {
+			return validSize, records
+		}
+
+		validSize = counter.n
+		records++
+	}
+}
@@ -0,0 +1,161 @@
+package warc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// crashedWARCFile writes a WARC file of 3 records with a rotator, then turns it
+// into the .open file a crash while writing its last record would leave.
+func crashedWARCFile(t *testing.T, compression, dictionary string) string {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.Compression = compression
+	rotatorSettings.CompressionDictionary = dictionary
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 1, 3, 1000)
+	closeTestRotator(records, doneChannels)
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc*"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v (%v)", paths, err)
+	}
+
+	stat, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Truncate(paths[0], stat.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(paths[0], paths[0]+".open"); err != nil {
+		t.Fatal(err)
+	}
+
+	return rotatorSettings.OutputDirectory
+}
+
+func TestRecoverWARCFiles(t *testing.T) {
+	for name, settings := range map[string][2]string{
+		"uncompressed":    {"", ""},
+		"GZIP":            {"GZIP", ""},
+		"ZSTD":            {"ZSTD", ""},
+		"ZSTD dictionary": {"ZSTD", "testdata/dictionary"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := crashedWARCFile(t, settings[0], settings[1])
+
+			recovered, err := RecoverWARCFiles(dir, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(recovered) != 1 {
+				t.Fatalf("expected 1 recovered file, got %d", len(recovered))
+			}
+
+			// The warcinfo record and the 2 first records are complete
+			if recovered[0].Records != 3 || recovered[0].TruncatedBytes <= 0 || recovered[0].Removed {
+				t.Errorf("unexpected recovery %+v", recovered[0])
+			}
+
+			if recovered[0].Path != strings.TrimSuffix(recovered[0].OpenPath, ".open") {
+				t.Errorf("unexpected path %s", recovered[0].Path)
+			}
+
+			file, err := os.Open(recovered[0].Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			reader, err := NewReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var types []string
+			var warcinfoID string
+			for {
+				record, eol, err := reader.ReadRecord()
+				if eol {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read the recovered file: %v", err)
+				}
+
+				types = append(types, record.Header.Get("WARC-Type"))
+
+				switch record.Header.Get("WARC-Type") {
+				case "warcinfo":
+					warcinfoID = record.Header.Get("WARC-Record-ID")
+				case "metadata":
+					if record.Header.Get("WARC-Warcinfo-ID") != warcinfoID {
+						t.Errorf("expected WARC-Warcinfo-ID %s, got %s", warcinfoID, record.Header.Get("WARC-Warcinfo-ID"))
+					}
+
+					if content := readAllFromStart(t, record.Content); !strings.Contains(content, "truncated-bytes: ") {
+						t.Errorf("unexpected metadata record content %q", content)
+					}
+				}
+				record.Content.Close()
+			}
+
+			if strings.Join(types, ",") != "warcinfo,resource,resource,metadata" {
+				t.Errorf("unexpected records %v", types)
+			}
+		})
+	}
+}
+
+func TestRecoverWARCFileWithoutCompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "TEST-00001.warc.gz.open")
+
+	if err := os.WriteFile(path, []byte("\x1f\x8b\x08\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := RecoverWARCFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !recovered.Removed || recovered.Path != "" {
+		t.Errorf("expected the file to be removed, got %+v", recovered)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed: %v", path, err)
+	}
+}
+
+func TestRotatorRecoverOpenFiles(t *testing.T) {
+	dir := crashedWARCFile(t, "GZIP", "")
+
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.OutputDirectory = dir
+	rotatorSettings.RecoverOpenFiles = true
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeTestRotator(records, doneChannels)
+
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*.open")); len(paths) != 0 {
+		t.Errorf("expected no .open file left, got %v", paths)
+	}
+
+	if counts := countRecordsPerFile(t, dir); len(counts) != 2 {
+		t.Errorf("expected the recovered file and a new one, got %v", counts)
+	}
+}
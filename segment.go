@@ -0,0 +1,260 @@
+package warc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/CorentinB/warc/pkg/spooledtempfile"
+	"github.com/google/uuid"
+)
+
+// A record which block is too large is written as several records (WARC 1.1,
+// section 5.18 and 6.8): the first segment keeps the type and header of the
+// record, with WARC-Segment-Number 1, and the rest of the block is written in
+// continuation records referring to it with WARC-Segment-Origin-ID. The last
+// one tells the length of the whole block with WARC-Segment-Total-Length.
+
+// segmentContent is a section of the content of a segmented record.
+type segmentContent struct {
+	content  spooledtempfile.ReadWriteSeekCloser
+	offset   int64
+	size     int64
+	position int64
+}
+
+func (s *segmentContent) Read(p []byte) (int, error) {
+	if s.position >= s.size {
+		return 0, io.EOF
+	}
+
+	n, err := s.ReadAt(p[:min(int64(len(p)), s.size-s.position)], s.position)
+	s.position += int64(n)
+
+	if err == io.EOF && s.position < s.size {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (s *segmentContent) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+
+	if remaining := s.size - off; int64(len(p)) > remaining {
+		n, err := s.content.ReadAt(p[:remaining], s.offset+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	return s.content.ReadAt(p, s.offset+off)
+}
+
+func (s *segmentContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.position
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	s.position = offset
+
+	return offset, nil
+}
+
+func (s *segmentContent) Write([]byte) (int, error) {
+	return 0, errors.New("segments are read-only")
+}
+
+// Close doesn't close the content of the record, which is shared by all its segments.
+func (s *segmentContent) Close() error {
+	return nil
+}
+
+func (s *segmentContent) FileName() string {
+	return ""
+}
+
+func (s *segmentContent) Len() int {
+	return int(s.size)
+}
+
+// segmentRecord returns the segments of record when its block is larger than
+// segmentSize, else record itself. The content of a segmented record must be
+// closed once its segments are written.
+func segmentRecord(record *Record, segmentSize int64) []*Record {
+	length, err := strconv.ParseInt(record.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		length = int64(getContentLength(record.Content))
+	}
+
+	if segmentSize <= 0 || length <= segmentSize {
+		return []*Record{record}
+	}
+
+	if record.Header.Get("WARC-Record-ID") == "" {
+		record.Header.Set("WARC-Record-ID", "<urn:uuid:"+uuid.NewString()+">")
+	}
+
+	var segments []*Record
+
+	for offset, number := int64(0), 1; offset < length; offset, number = offset+segmentSize, number+1 {
+		size := min(segmentSize, length-offset)
+		segment := &Record{
+			Content: &segmentContent{content: record.Content, offset: offset, size: size},
+			Version: record.Version,
+		}
+
+		if number == 1 {
+			segment.Header = record.Header
+			// The block digests are those of the segments
+			segment.Header.Del("WARC-Block-Digest")
+		} else {
+			segment.Header = NewHeader()
+			segment.Header.Set("WARC-Type", "continuation")
+			segment.Header.Set("WARC-Target-URI", record.Header.Get("WARC-Target-URI"))
+			segment.Header.Set("WARC-Date", record.Header.Get("WARC-Date"))
+			segment.Header.Set("WARC-Segment-Origin-ID", record.Header.Get("WARC-Record-ID"))
+		}
+
+		segment.Header.Set("WARC-Segment-Number", strconv.Itoa(number))
+		segment.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+
+		if offset+segmentSize >= length {
+			segment.Header.Set("WARC-Segment-Total-Length", strconv.FormatInt(length, 10))
+		}
+
+		segments = append(segments, segment)
+	}
+
+	return segments
+}
+
+// ReadReassembledRecord reads the next record like ReadRecord, except that the
+// segments of a segmented record are reassembled in a single record, which
+// header is the one of its first segment, without WARC-Segment-Number, and
+// with the Content-Length and WARC-Block-Digest of the whole block. Records
+// interleaved with the segments are returned after it. Continuation records
+// which first segment isn't in the file are returned as they are. The segments
+// written to several files are only found by a reader created by
+// NewMultiFileReader.
+func (r *Reader) ReadReassembledRecord() (*Record, bool, error) {
+	record, eol, err := r.nextRecord()
+	if eol || err != nil || record.Header.Get("WARC-Segment-Number") != "1" || record.Header.Get("WARC-Segment-Total-Length") != "" {
+		return record, eol, err
+	}
+
+	var (
+		origin  = record.Header.Get("WARC-Record-ID")
+		content = spooledtempfile.NewSpooledTempFile("warc", "", r.threshold, false, -1)
+		skipped []*Record
+		length  int64
+	)
+
+	// Records read while looking for the segments are returned next
+	defer func() {
+		r.pending = append(skipped, r.pending...)
+	}()
+
+	appendSegment := func(segment *Record) error {
+		defer segment.Content.Close()
+
+		n, err := io.Copy(content, segment.Content)
+		length += n
+
+		return err
+	}
+
+	if err := appendSegment(record); err != nil {
+		content.Close()
+		return nil, false, err
+	}
+
+	for number := 2; ; number++ {
+		var segment *Record
+
+		for segment == nil {
+			next, eol, err := r.nextRecord()
+			if eol {
+				content.Close()
+				return nil, false, fmt.Errorf("segmented record %s is incomplete", origin)
+			}
+			if err != nil {
+				content.Close()
+				return nil, false, err
+			}
+
+			if next.Header.Get("WARC-Type") == "continuation" && next.Header.Get("WARC-Segment-Origin-ID") == origin {
+				segment = next
+			} else {
+				skipped = append(skipped, next)
+			}
+		}
+
+		if segment.Header.Get("WARC-Segment-Number") != strconv.Itoa(number) {
+			content.Close()
+			segment.Content.Close()
+			return nil, false, fmt.Errorf("segmented record %s: expected segment %d, got %s", origin, number, segment.Header.Get("WARC-Segment-Number"))
+		}
+
+		totalLength := segment.Header.Get("WARC-Segment-Total-Length")
+
+		if err := appendSegment(segment); err != nil {
+			content.Close()
+			return nil, false, err
+		}
+
+		if totalLength != "" {
+			if totalLength != strconv.FormatInt(length, 10) {
+				content.Close()
+				return nil, false, fmt.Errorf("segmented record %s: WARC-Segment-Total-Length is %s, got %d bytes", origin, totalLength, length)
+			}
+
+			break
+		}
+	}
+
+	// The header read no longer matches the reassembled record
+	record.rawHeader = nil
+	record.Header.Del("WARC-Segment-Number")
+	record.Header.Del("WARC-Segment-Total-Length")
+	record.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	record.Header.Set("WARC-Block-Digest", "sha1:"+GetSHA1(content))
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		content.Close()
+		return nil, false, err
+	}
+
+	record.Content = content
+
+	return record, false, nil
+}
+
+// nextRecord returns the first record read ahead by ReadReassembledRecord, if
+// any, else the next record of the file.
+func (r *Reader) nextRecord() (*Record, bool, error) {
+	if len(r.pending) > 0 {
+		record := r.pending[0]
+		r.pending = r.pending[1:]
+
+		return record, false, nil
+	}
+
+	return r.ReadRecord()
+}
@@ -0,0 +1,294 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// segmentTestContent returns size bytes which aren't all the same, so that
+// misplaced segments are noticed.
+func segmentTestContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte('a' + i%26 + i/1000%2)
+	}
+
+	return content
+}
+
+// readReassembledRecords reads the WARC files at paths, in order, with
+// ReadReassembledRecord, returning the records and their contents.
+func readReassembledRecords(t *testing.T, paths ...string) (records []*Record, contents [][]byte) {
+	reader, err := NewMultiFileReader(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for {
+		record, eol, err := reader.ReadReassembledRecord()
+		if eol {
+			return records, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(record.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Content.Close()
+
+		records = append(records, record)
+		contents = append(contents, content)
+	}
+}
+
+func TestRotatorSegmentation(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.SegmentSize = 1000
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := segmentTestContent(2500)
+
+	batch := NewRecordBatch(make(chan struct{}, 1))
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "resource")
+	record.Header.Set("WARC-Target-URI", "http://example.com/large")
+	record.Content.Write(content)
+	batch.Records = append(batch.Records, record)
+
+	records <- batch
+	<-batch.FeedbackChan
+
+	// A record smaller than SegmentSize isn't segmented
+	writeTestBatches(t, records, 1, 1, 10)
+	closeTestRotator(records, doneChannels)
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc.gz"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v (%v)", paths, err)
+	}
+
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var segments []*Record
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Content.Close()
+
+		if record.Header.Get("WARC-Segment-Number") != "" {
+			segments = append(segments, record)
+		}
+	}
+
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+
+	origin := segments[0].Header.Get("WARC-Record-ID")
+
+	for i, segment := range segments {
+		expectedType := "continuation"
+		if i == 0 {
+			expectedType = "resource"
+		} else if segment.Header.Get("WARC-Segment-Origin-ID") != origin {
+			t.Errorf("segment %d: expected WARC-Segment-Origin-ID %s, got %q", i+1, origin, segment.Header.Get("WARC-Segment-Origin-ID"))
+		}
+
+		if segment.Header.Get("WARC-Type") != expectedType {
+			t.Errorf("segment %d: expected WARC-Type %s, got %q", i+1, expectedType, segment.Header.Get("WARC-Type"))
+		}
+
+		if segment.Header.Get("WARC-Segment-Number") != strconv.Itoa(i+1) {
+			t.Errorf("segment %d: got WARC-Segment-Number %q", i+1, segment.Header.Get("WARC-Segment-Number"))
+		}
+
+		if segment.Header.Get("WARC-Target-URI") != "http://example.com/large" {
+			t.Errorf("segment %d: got WARC-Target-URI %q", i+1, segment.Header.Get("WARC-Target-URI"))
+		}
+
+		expectedLength := "1000"
+		if i == 2 {
+			expectedLength = "500"
+		}
+
+		if segment.Header.Get("Content-Length") != expectedLength {
+			t.Errorf("segment %d: expected Content-Length %s, got %q", i+1, expectedLength, segment.Header.Get("Content-Length"))
+		}
+	}
+
+	if totalLength := segments[2].Header.Get("WARC-Segment-Total-Length"); totalLength != "2500" {
+		t.Errorf("expected WARC-Segment-Total-Length 2500 on the last segment, got %q", totalLength)
+	}
+
+	if segments[0].Header.Get("WARC-Segment-Total-Length") != "" || segments[1].Header.Get("WARC-Segment-Total-Length") != "" {
+		t.Error("only the last segment should have WARC-Segment-Total-Length")
+	}
+
+	reassembled, contents := readReassembledRecords(t, paths[0])
+	if len(reassembled) != 3 {
+		t.Fatalf("expected warcinfo and 2 records, got %d records", len(reassembled))
+	}
+
+	if reassembled[1].Header.Get("WARC-Record-ID") != origin || !bytes.Equal(contents[1], content) {
+		t.Fatalf("the segmented record wasn't reassembled, got %d bytes", len(contents[1]))
+	}
+
+	if reassembled[1].Header.Get("Content-Length") != "2500" || reassembled[1].Header.Get("WARC-Segment-Number") != "" {
+		t.Errorf("unexpected header of the reassembled record: %v", reassembled[1].Header)
+	}
+
+	if reassembled[1].Header.Get("WARC-Block-Digest") != "sha1:"+GetSHA1(bytes.NewReader(content)) {
+		t.Errorf("unexpected WARC-Block-Digest %q", reassembled[1].Header.Get("WARC-Block-Digest"))
+	}
+}
+
+func TestRotatorSegmentationAcrossFiles(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.Compression = ""
+	rotatorSettings.SegmentSize = 1000
+	rotatorSettings.WarcMaxBytes = 1500
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := segmentTestContent(3500)
+
+	batch := NewRecordBatch(make(chan struct{}, 1))
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "resource")
+	record.Header.Set("WARC-Target-URI", "http://example.com/large")
+	record.Content.Write(content)
+	batch.Records = append(batch.Records, record)
+
+	records <- batch
+	<-batch.FeedbackChan
+	closeTestRotator(records, doneChannels)
+
+	// Every file holds a single segment, as each one exceeds WarcMaxBytes
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); !slices.Equal(counts, []int{1, 1, 1, 1}) {
+		t.Fatalf("expected 4 files of 1 record, got %v", counts)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(paths)
+
+	// The files are read as the rotator wrote them, the segments can't be
+	// reassembled from the first file alone
+	reader, err := NewMultiFileReader(paths[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for {
+		record, eol, err := reader.ReadReassembledRecord()
+		if eol {
+			t.Fatal("expected the segmented record to be incomplete in the first file")
+		}
+		if err != nil {
+			break
+		}
+		record.Content.Close()
+	}
+
+	reassembled, contents := readReassembledRecords(t, paths...)
+
+	var found bool
+	for i, record := range reassembled {
+		if record.Header.Get("WARC-Type") == "resource" {
+			found = true
+
+			if !bytes.Equal(contents[i], content) {
+				t.Errorf("the segmented record wasn't reassembled, got %d bytes", len(contents[i]))
+			}
+		} else if record.Header.Get("WARC-Type") != "warcinfo" {
+			t.Errorf("unexpected %s record", record.Header.Get("WARC-Type"))
+		}
+	}
+
+	if !found {
+		t.Fatal("the segmented record wasn't read")
+	}
+}
+
+func TestReadReassembledRecordInterleaved(t *testing.T) {
+	var (
+		buf     bytes.Buffer
+		content = segmentTestContent(250)
+	)
+
+	writer, err := NewWriter(&buf, "test.warc", "", "", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "resource")
+	record.Content.Write(content)
+
+	other := NewRecord("", false)
+	other.Header.Set("WARC-Type", "metadata")
+	other.Content.Write([]byte("interleaved"))
+
+	segments := segmentRecord(record, 100)
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+
+	// Another record is written between the segments
+	for _, record := range []*Record{segments[0], segments[1], other, segments[2]} {
+		if _, err := writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "test.warc")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reassembled, contents := readReassembledRecords(t, path)
+	if len(reassembled) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(reassembled))
+	}
+
+	if reassembled[0].Header.Get("WARC-Type") != "resource" || !bytes.Equal(contents[0], content) {
+		t.Errorf("the segmented record wasn't reassembled first, got a %s record of %d bytes", reassembled[0].Header.Get("WARC-Type"), len(contents[0]))
+	}
+
+	if reassembled[1].Header.Get("WARC-Type") != "metadata" || string(contents[1]) != "interleaved" {
+		t.Errorf("the interleaved record wasn't returned next, got a %s record", reassembled[1].Header.Get("WARC-Type"))
+	}
+}
@@ -0,0 +1,222 @@
+package warc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Storage is where the writers of a rotator create their WARC files. A file is
+// created with the .open suffix, records are appended to it, and it is finalized
+// under its final name once closed. LocalStorage is the default, writing to
+// RotatorSettings.OutputDirectory.
+type Storage interface {
+	// Create creates the file name, it must fail with an error matching
+	// os.ErrExist if it already exists
+	Create(name string) (StorageFile, error)
+	// Finalize makes the closed file name available as finalName. When sync is
+	// true, the finalization must be durable once it returns
+	Finalize(name, finalName string, sync bool) error
+	// List returns the names of the files of the storage, sorted
+	List() ([]string, error)
+}
+
+// StorageFile is a WARC file being written to a Storage, writes append to it.
+type StorageFile interface {
+	Write(p []byte) (int, error)
+	// Size returns the number of bytes written to the file
+	Size() int64
+	// Sync makes the bytes written so far durable
+	Sync() error
+	Close() error
+}
+
+// LocalStorage writes WARC files to a directory of the local filesystem.
+type LocalStorage struct {
+	Directory string
+}
+
+// NewLocalStorage returns a LocalStorage writing to dir.
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{Directory: dir}
+}
+
+// Path returns the path of the file name of the storage.
+func (s *LocalStorage) Path(name string) string {
+	return filepath.Join(s.Directory, name)
+}
+
+func (s *LocalStorage) Create(name string) (StorageFile, error) {
+	file, err := os.OpenFile(s.Path(name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localFile{File: file}, nil
+}
+
+func (s *LocalStorage) Finalize(name, finalName string, sync bool) error {
+	err := os.Rename(s.Path(name), s.Path(finalName))
+	if err != nil {
+		return err
+	}
+
+	// The rename is only durable once the directory is synced
+	if sync {
+		return syncDir(s.Directory)
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) List() ([]string, error) {
+	entries, err := os.ReadDir(s.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// localFile counts the bytes written to a file of a LocalStorage.
+type localFile struct {
+	*os.File
+	size int64
+}
+
+func (f *localFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *localFile) Size() int64 {
+	return f.size
+}
+
+// MemoryStorage keeps WARC files in memory, e.g. to test code writing WARC
+// files without touching the filesystem.
+type MemoryStorage struct {
+	mutex sync.Mutex
+	files map[string]*memoryFile
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]*memoryFile)}
+}
+
+func (s *MemoryStorage) Create(name string) (StorageFile, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.files[name]; exists {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrExist)
+	}
+
+	file := &memoryFile{storage: s}
+	s.files[name] = file
+
+	return file, nil
+}
+
+func (s *MemoryStorage) Finalize(name, finalName string, sync bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, exists := s.files[name]
+	if !exists {
+		return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+
+	if !file.closed {
+		return fmt.Errorf("%s isn't closed", name)
+	}
+
+	if _, exists := s.files[finalName]; exists {
+		return fmt.Errorf("%s: %w", finalName, os.ErrExist)
+	}
+
+	delete(s.files, name)
+	s.files[finalName] = file
+
+	return nil
+}
+
+func (s *MemoryStorage) List() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// ReadFile returns a copy of the content of the file name.
+func (s *MemoryStorage) ReadFile(name string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, exists := s.files[name]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+
+	return bytes.Clone(file.data.Bytes()), nil
+}
+
+type memoryFile struct {
+	storage *MemoryStorage
+	data    bytes.Buffer
+	closed  bool
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	f.storage.mutex.Lock()
+	defer f.storage.mutex.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.data.Write(p)
+}
+
+func (f *memoryFile) Size() int64 {
+	f.storage.mutex.Lock()
+	defer f.storage.mutex.Unlock()
+
+	return int64(f.data.Len())
+}
+
+func (f *memoryFile) Sync() error {
+	return nil
+}
+
+func (f *memoryFile) Close() error {
+	f.storage.mutex.Lock()
+	defer f.storage.mutex.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package warc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRotatorMemoryStorage(t *testing.T) {
+	var (
+		storage         = NewMemoryStorage()
+		rotatorSettings = defaultRotatorSettings(t)
+		closed          []WARCFileInfo
+	)
+
+	rotatorSettings.Storage = storage
+	rotatorSettings.WarcMaxRecords = 2
+	rotatorSettings.OnFileClosed = func(info WARCFileInfo) {
+		closed = append(closed, info)
+	}
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 3, 1, 10)
+	closeTestRotator(records, doneChannels)
+
+	// Nothing is written to the output directory
+	if entries, _ := os.ReadDir(rotatorSettings.OutputDirectory); len(entries) != 0 {
+		t.Errorf("expected an empty output directory, got %d files", len(entries))
+	}
+
+	names, err := storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 || len(closed) != 2 {
+		t.Fatalf("expected 2 files, got %v and %d closed", names, len(closed))
+	}
+
+	total := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, ".open") {
+			t.Errorf("file %s wasn't finalized", name)
+		}
+
+		data, err := storage.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewReader(io.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if record.Header.Get("WARC-Type") != "warcinfo" {
+				total++
+			}
+			record.Content.Close()
+		}
+	}
+
+	if total != 3 {
+		t.Errorf("expected 3 records, got %d", total)
+	}
+
+	// Hooks get the names of the files in the storage
+	if closed[0].Path != names[0] && closed[0].Path != names[1] {
+		t.Errorf("unexpected path %q", closed[0].Path)
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	file, err := storage.Create("a.warc.open")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Create("a.warc.open"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected os.ErrExist, got %v", err)
+	}
+
+	if _, err := file.Write([]byte("data")); err != nil || file.Size() != 4 {
+		t.Errorf("unexpected size %d (%v)", file.Size(), err)
+	}
+
+	if err := storage.Finalize("a.warc.open", "a.warc", true); err == nil {
+		t.Error("expected an error when finalizing an open file")
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Finalize("a.warc.open", "a.warc", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := storage.ReadFile("a.warc"); err != nil || string(data) != "data" {
+		t.Errorf("unexpected content %q (%v)", data, err)
+	}
+
+	if names, _ := storage.List(); len(names) != 1 || names[0] != "a.warc" {
+		t.Errorf("unexpected files %v", names)
+	}
+}
+
+func TestRotatorRecoverOpenFilesStorage(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.Storage = NewMemoryStorage()
+	rotatorSettings.RecoverOpenFiles = true
+
+	if _, _, err := rotatorSettings.NewWARCRotator(); err == nil {
+		t.Error("expected an error when recovering files of a MemoryStorage")
+	}
+}
@@ -0,0 +1,91 @@
+package warc
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out reservations so that their total cost doesn't exceed
+// rate per second. It is shared by the writers of a rotator for global limits.
+type rateLimiter struct {
+	mutex sync.Mutex
+	rate  float64
+	// next is when the cost reserved so far is paid off
+	next time.Time
+}
+
+// newRateLimiter returns a limiter of rate per second, or nil if rate isn't positive.
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{rate: rate}
+}
+
+// reserve reserves cost and returns how long to wait before spending it.
+func (l *rateLimiter) reserve(cost float64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(cost / l.rate * float64(time.Second)))
+
+	return delay
+}
+
+// throttledWriter limits the bytes and write operations per second written to
+// a WARC file, sleeping before writes that would exceed any of its limiters.
+// Blocking the writer goroutine fills the records channel of the rotator,
+// which holds back the clients sending batches to it.
+type throttledWriter struct {
+	// file is the WARC file currently written by the writer
+	file          StorageFile
+	bytesLimiters []*rateLimiter
+	opsLimiters   []*rateLimiter
+	// throttled is the time spent waiting since it was last reset
+	throttled time.Duration
+}
+
+// newThrottledWriter returns a throttledWriter limited by the non-nil limiters.
+func newThrottledWriter(bytesLimiters, opsLimiters []*rateLimiter) *throttledWriter {
+	writer := &throttledWriter{}
+
+	for _, limiter := range bytesLimiters {
+		if limiter != nil {
+			writer.bytesLimiters = append(writer.bytesLimiters, limiter)
+		}
+	}
+
+	for _, limiter := range opsLimiters {
+		if limiter != nil {
+			writer.opsLimiters = append(writer.opsLimiters, limiter)
+		}
+	}
+
+	return writer
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var delay time.Duration
+
+	for _, limiter := range w.bytesLimiters {
+		delay = max(delay, limiter.reserve(float64(len(p))))
+	}
+
+	for _, limiter := range w.opsLimiters {
+		delay = max(delay, limiter.reserve(1))
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+		w.throttled += delay
+	}
+
+	return w.file.Write(p)
+}
@@ -0,0 +1,67 @@
+package warc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+
+	if delay := limiter.reserve(50); delay != 0 {
+		t.Errorf("expected the first reservation not to wait, got %s", delay)
+	}
+
+	// The 50 first units take half a second to be paid off
+	if delay := limiter.reserve(50); delay < 450*time.Millisecond || delay > 500*time.Millisecond {
+		t.Errorf("expected a delay of about 500ms, got %s", delay)
+	}
+
+	if newRateLimiter(0) != nil {
+		t.Error("expected no limiter without a rate")
+	}
+}
+
+func TestRotatorWriteRateLimit(t *testing.T) {
+	for name, setup := range map[string]func(*RotatorSettings){
+		"global bytes":     func(s *RotatorSettings) { s.MaxWriteBytesPerSecond = 20000 },
+		"per writer bytes": func(s *RotatorSettings) { s.MaxWriterBytesPerSecond = 20000 },
+		"global ops":       func(s *RotatorSettings) { s.MaxWriteOpsPerSecond = 10 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				rotatorSettings = defaultRotatorSettings(t)
+				metrics         = NewCaptureMetrics()
+			)
+
+			rotatorSettings.Storage = NewMemoryStorage()
+			rotatorSettings.Compression = ""
+			rotatorSettings.Metrics = metrics
+			setup(rotatorSettings)
+
+			records, doneChannels, err := rotatorSettings.NewWARCRotator()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// About 30KB written in about 10 operations, so at least a second of throttling
+			start := time.Now()
+			writeTestBatches(t, records, 10, 1, 3000)
+			closeTestRotator(records, doneChannels)
+
+			if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+				t.Errorf("expected the writes to be throttled, took %s", elapsed)
+			}
+
+			var text strings.Builder
+			if err := metrics.WriteText(&text); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(text.String(), `warc_writer_throttled_seconds_total{writer="0"}`) {
+				t.Errorf("expected the throttling to be reported, got:\n%s", text.String())
+			}
+		})
+	}
+}
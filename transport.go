@@ -1,40 +1,220 @@
 package warc
 
 import (
+	"bufio"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
 	gzip "github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
 )
 
+// DefaultAcceptEncodings is the list of content-codings advertised in the
+// Accept-Encoding header when HTTPClientSettings.AcceptEncodings is empty.
+var DefaultAcceptEncodings = []string{"gzip"}
+
+// SupportedContentEncodings is the list of content-codings that the client
+// is able to decode when DecompressBody is enabled.
+var SupportedContentEncodings = []string{"gzip", "x-gzip", "br", "zstd", "deflate", "identity"}
+
 type customTransport struct {
 	t              http.Transport
+	client         *CustomHTTPClient
+	acceptEncoding string
 	decompressBody bool
 }
 
 func (t *customTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	req = req.Clone(req.Context())
-	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", t.acceptEncoding)
+
+	if t.client != nil && t.client.dedupeOptions.ConditionalRequests {
+		t.client.setConditionalHeaders(req)
+	}
+
+	// Failures that happen before a connection is established (DNS, dial, TLS) are reported
+	// and archived here, the others are while writing the records of the connection
+	var (
+		start   = time.Now()
+		gotConn atomic.Bool
+	)
+
+	if t.client != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(httptrace.GotConnInfo) {
+				gotConn.Store(true)
+			},
+		}))
+	}
 
 	resp, err = t.t.RoundTrip(req)
 	if err != nil {
+		if t.client != nil && !gotConn.Load() {
+			t.client.emit(&Event{
+				Err:       err,
+				Func:      "RoundTrip",
+				TargetURI: req.URL.String(),
+				Elapsed:   time.Since(start),
+			})
+
+			if t.client.archiveFailures {
+				t.client.archiveConnectionFailure(req.URL.String(), err)
+			}
+		}
+
 		return resp, err
 	}
 
 	// if the client have been created with decompressBody = true,
-	// we decompress the resp.Body if we received a compressed body
-	if t.decompressBody {
-		switch resp.Header.Get("Content-Encoding") {
-		case "gzip":
-			resp.Body, err = gzip.NewReader(resp.Body)
-		}
+	// we decompress the resp.Body if we received a compressed body.
+	// The WARC records are not affected, they are written from the
+	// raw bytes read on the connection.
+	if t.decompressBody && req.Method != http.MethodHead {
+		decodeContentEncoding(resp)
 	}
 
 	return
 }
 
-func newCustomTransport(dialer *customDialer, decompressBody bool, TLSHandshakeTimeout time.Duration) (t *customTransport, err error) {
+// decodeContentEncoding wraps resp.Body with the decoders needed to undo
+// every content-coding listed in the Content-Encoding header. Codings are
+// listed in the order they were applied, so they are undone in reverse order.
+// If one of the codings isn't supported, the body is left untouched.
+func decodeContentEncoding(resp *http.Response) {
+	var codings []string
+	for _, value := range resp.Header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	if len(codings) == 0 {
+		return
+	}
+
+	for _, coding := range codings {
+		if !isSupportedContentEncoding(coding) {
+			return
+		}
+	}
+
+	body := resp.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		body = &contentDecoder{
+			coding: codings[i],
+			src:    body,
+		}
+	}
+
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+func isSupportedContentEncoding(coding string) bool {
+	for _, supported := range SupportedContentEncodings {
+		if coding == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contentDecoder lazily initializes the decoder for a single content-coding
+// on the first Read, so that empty bodies (204, 304...) don't fail.
+type contentDecoder struct {
+	src     io.ReadCloser
+	decoder io.Reader
+	closer  func() error
+	coding  string
+	err     error
+}
+
+func (cd *contentDecoder) Read(p []byte) (int, error) {
+	if cd.err != nil {
+		return 0, cd.err
+	}
+
+	if cd.decoder == nil {
+		cd.decoder, cd.closer, cd.err = newContentDecoder(cd.coding, cd.src)
+		if cd.err != nil {
+			return 0, cd.err
+		}
+	}
+
+	return cd.decoder.Read(p)
+}
+
+func (cd *contentDecoder) Close() error {
+	var err error
+	if cd.closer != nil {
+		err = cd.closer()
+	}
+
+	return errors.Join(err, cd.src.Close())
+}
+
+func newContentDecoder(coding string, r io.Reader) (decoder io.Reader, closer func() error, err error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return gzipReader, gzipReader.Close, nil
+	case "br":
+		return brotli.NewReader(r), nil, nil
+	case "zstd":
+		zstdReader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return zstdReader, func() error { zstdReader.Close(); return nil }, nil
+	case "deflate":
+		// The "deflate" coding is supposed to be zlib-wrapped (RFC 9110, section 8.4.1.2),
+		// but a lot of servers send raw DEFLATE streams, so we sniff the zlib header.
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zlibReader, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return zlibReader, zlibReader.Close, nil
+		}
+
+		flateReader := flate.NewReader(br)
+
+		return flateReader, flateReader.Close, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported content-encoding: %s", coding)
+}
+
+func newCustomTransport(dialer *customDialer, decompressBody bool, acceptEncodings []string, TLSHandshakeTimeout time.Duration) (t *customTransport, err error) {
 	t = new(customTransport)
 
 	t.t = http.Transport{
@@ -57,6 +237,20 @@ func newCustomTransport(dialer *customDialer, decompressBody bool, TLSHandshakeT
 		DisableKeepAlives:     true,
 	}
 
+	if len(acceptEncodings) == 0 {
+		acceptEncodings = DefaultAcceptEncodings
+	}
+
+	for _, coding := range acceptEncodings {
+		// Strip the quality value, if any (e.g. "br;q=0.8")
+		name, _, _ := strings.Cut(coding, ";")
+		if decompressBody && !isSupportedContentEncoding(strings.ToLower(strings.TrimSpace(name))) {
+			return nil, fmt.Errorf("unsupported content-encoding: %s", coding)
+		}
+	}
+
+	t.client = dialer.client
+	t.acceptEncoding = strings.Join(acceptEncodings, ", ")
 	t.decompressBody = decompressBody
 
 	return t, nil
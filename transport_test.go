@@ -0,0 +1,224 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	gzip "github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+func encodeTestBody(t *testing.T, coding string, body []byte) []byte {
+	var (
+		buf    bytes.Buffer
+		writer io.WriteCloser
+		err    error
+	)
+
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "br":
+		writer = brotli.NewWriter(&buf)
+	case "zstd":
+		writer, err = zstd.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		writer, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		t.Fatalf("unknown test coding %s", coding)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// readResponsePayloads returns the raw HTTP payload of every response record in the WARC file at path.
+func readResponsePayloads(t *testing.T, path string) (payloads [][]byte) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %q: %v", path, err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatalf("warc.NewReader failed for %q: %v", path, err)
+	}
+
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			break
+		}
+		if err != nil {
+			t.Fatalf("warc.ReadRecord failed: %v", err)
+		}
+
+		if record.Header.Get("WARC-Type") == "response" {
+			resp, err := http.ReadResponse(bufio.NewReader(record.Content), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payloads = append(payloads, payload)
+		}
+
+		record.Content.Close()
+	}
+
+	return payloads
+}
+
+func TestHTTPClientDecompressBody(t *testing.T) {
+	fileBytes, err := os.ReadFile(path.Join("testdata", "image.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		contentEncoding string
+		codings         []string
+	}{
+		{"gzip", "gzip", []string{"gzip"}},
+		{"brotli", "br", []string{"br"}},
+		{"zstd", "zstd", []string{"zstd"}},
+		{"deflate", "deflate", []string{"deflate"}},
+		{"raw deflate", "deflate", []string{"raw-deflate"}},
+		{"stacked", "gzip, br", []string{"gzip", "br"}},
+		{"identity", "identity", []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				rotatorSettings = defaultRotatorSettings(t)
+				errWg           sync.WaitGroup
+				acceptEncoding  string
+			)
+
+			encodedBody := fileBytes
+			for _, coding := range tc.codings {
+				encodedBody = encodeTestBody(t, coding, encodedBody)
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+
+				w.Header().Set("Content-Type", "image/svg+xml")
+				w.Header().Set("Content-Encoding", tc.contentEncoding)
+				w.WriteHeader(http.StatusOK)
+				w.Write(encodedBody)
+			}))
+			defer server.Close()
+
+			httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{
+				RotatorSettings: rotatorSettings,
+				DecompressBody:  true,
+				AcceptEncodings: []string{"gzip", "br", "zstd", "deflate"},
+			})
+			if err != nil {
+				t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+			}
+
+			errWg.Add(1)
+			go func() {
+				defer errWg.Done()
+				for err := range httpClient.ErrChan {
+					t.Errorf("Error writing to WARC: %s", err.Err.Error())
+				}
+			}()
+
+			resp, err := httpClient.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			httpClient.Close()
+			errWg.Wait()
+
+			if acceptEncoding != "gzip, br, zstd, deflate" {
+				t.Errorf("unexpected Accept-Encoding header: %q", acceptEncoding)
+			}
+
+			if !bytes.Equal(body, fileBytes) {
+				t.Errorf("decoded body doesn't match the original body")
+			}
+
+			if len(tc.codings) > 0 && resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("Content-Encoding header should have been removed, got %q", resp.Header.Get("Content-Encoding"))
+			}
+
+			files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, path := range files {
+				testFileHash(t, path)
+
+				payloads := readResponsePayloads(t, path)
+				if len(payloads) != 1 {
+					t.Fatalf("expected 1 response record, got %d", len(payloads))
+				}
+
+				if !bytes.Equal(payloads[0], encodedBody) {
+					t.Errorf("WARC payload doesn't match the raw bytes sent by the server")
+				}
+			}
+		})
+	}
+}
+
+func TestNewCustomTransportUnsupportedEncoding(t *testing.T) {
+	_, err := newCustomTransport(new(customDialer), true, []string{"gzip", "compress"}, 0)
+	if err == nil {
+		t.Fatal("expected an error for an unsupported content-encoding")
+	}
+
+	_, err = newCustomTransport(new(customDialer), true, []string{"br;q=1.0", "gzip;q=0.5"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Without decompression, any content-coding can be advertised
+	_, err = newCustomTransport(new(customDialer), false, []string{"compress"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
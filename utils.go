@@ -9,15 +9,24 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/CorentinB/warc/pkg/spooledtempfile"
 	gzip "github.com/klauspost/compress/gzip"
+	"github.com/paulbellamy/ratecounter"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
 )
 
 func GetSHA1(r io.Reader) string {
@@ -47,6 +56,25 @@ func GetSHA256Base16(r io.Reader) string {
 	return hex.EncodeToString(sha.Sum(nil))
 }
 
+// countingWriter counts the bytes written to it, and discards them.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// toggleWriter forwards writes to w, which can be swapped while it is in use.
+type toggleWriter struct {
+	w io.Writer
+}
+
+func (tw *toggleWriter) Write(p []byte) (int, error) {
+	return tw.w.Write(p)
+}
+
 // splitKeyValue parses WARC record header fields.
 func splitKeyValue(line string) (string, string) {
 	parts := strings.SplitN(line, ":", 2)
@@ -56,96 +84,224 @@ func splitKeyValue(line string) (string, string) {
 	return parts[0], strings.TrimSpace(parts[1])
 }
 
-func isHTTPRequest(line string) bool {
-	httpMethods := []string{"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "CONNECT ", "OPTIONS ", "TRACE ", "PATCH "}
-	protocols := []string{"HTTP/1.0", "HTTP/1.1"}
+// buildWARCTargetURI returns the canonical URI of the resource requested by req,
+// as read from a connection established for scheme. Origin-form, absolute-form,
+// authority-form (CONNECT) and asterisk-form (OPTIONS *) targets are supported,
+// the scheme and host are lowercased and the port is removed if it is the default
+// one for the scheme, so that it matches the URL the request was sent for.
+func buildWARCTargetURI(scheme string, req *http.Request) (string, error) {
+	var (
+		host   = req.Host
+		target = req.RequestURI
+	)
+
+	switch {
+	case req.Method == http.MethodConnect:
+		// The target is the authority of the tunnel (e.g. "example.com:443")
+		host, target = req.RequestURI, ""
+	case target == "*":
+		target = ""
+	case req.URL.IsAbs():
+		scheme = req.URL.Scheme
+		host = req.URL.Host
+		target = req.URL.RequestURI()
+	}
 
-	for _, method := range httpMethods {
-		if strings.HasPrefix(line, method) {
-			for _, protocol := range protocols {
-				if strings.HasSuffix(line, protocol) {
-					return true
-				}
-			}
-		}
+	if host == "" {
+		return "", errors.New("unable to parse data necessary for WARC-Target-URI")
+	}
+
+	if target != "" && !strings.HasPrefix(target, "/") {
+		return "", fmt.Errorf("unable to parse data necessary for WARC-Target-URI: invalid request target %q", target)
+	}
+
+	scheme = strings.ToLower(scheme)
+
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// No port, IPv6 literals are enclosed in brackets that must be removed before joining
+		hostname, port = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ""
+	}
+
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	hostname = strings.ToLower(hostname)
+	if port != "" {
+		host = net.JoinHostPort(hostname, port)
+	} else if strings.Contains(hostname, ":") {
+		host = "[" + hostname + "]"
+	} else {
+		host = hostname
 	}
-	return false
+
+	return scheme + "://" + host + target, nil
 }
 
-// NewWriter creates a new WARC writer.
+// canonicalRequestURL returns the WARC-Target-URI the capture of req, a request
+// sent by the client, is written with, see buildWARCTargetURI.
+func canonicalRequestURL(req *http.Request) (string, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// The request as the server reads it, the fragment is never sent
+	return buildWARCTargetURI(req.URL.Scheme, &http.Request{
+		Method:     req.Method,
+		Host:       host,
+		RequestURI: req.URL.RequestURI(),
+		URL:        &url.URL{Path: req.URL.Path},
+	})
+}
+
+// NewParallelGZIPWriter creates a new WARC writer compressing its record with
+// GZIP at the given level (0 meaning the default level) by blocks of blockSize
+// bytes, up to blocks of them being compressed in parallel. The record is still
+// written as a single GZIP member. It pays off for large records only, as
+// goroutines and buffers are set up for each writer.
+func NewParallelGZIPWriter(writer io.Writer, fileName string, level, blockSize, blocks int) (*Writer, error) {
+	if level == 0 {
+		level = pgzip.DefaultCompression
+	}
+
+	gzipWriter, err := pgzip.NewWriterLevel(writer, level)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gzipWriter.SetConcurrency(blockSize, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		FileName:           fileName,
+		underlying:         writer,
+		Compression:        "GZIP",
+		ParallelGZIP:       true,
+		ParallelGZIPWriter: gzipWriter,
+		FileWriter:         bufio.NewWriter(gzipWriter),
+	}, nil
+}
+
+// NewWriter creates a new WARC writer, compression is the name of a
+// CompressionAlgorithm, compressing at the default level of the algorithm.
 func NewWriter(writer io.Writer, fileName string, compression string, contentLengthHeader string, newFileCreation bool, dictionary []byte) (*Writer, error) {
-	if compression != "" {
-		if compression == "GZIP" {
-			gzipWriter := gzip.NewWriter(writer)
-
-			return &Writer{
-				FileName:    fileName,
-				Compression: compression,
-				GZIPWriter:  gzipWriter,
-				FileWriter:  bufio.NewWriter(gzipWriter),
-			}, nil
-		} else if compression == "ZSTD" {
-			if newFileCreation && len(dictionary) > 0 {
-				dictionaryZstdwriter, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
-				if err != nil {
-					return nil, err
-				}
-
-				// Compress dictionary with ZSTD.
-				// TODO: Option to allow uncompressed dictionary (maybe? not sure there's any need.)
-				payload := dictionaryZstdwriter.EncodeAll(dictionary, nil)
-
-				// Magic number for skippable dictionary frame (0x184D2A5D).
-				// https://github.com/ArchiveTeam/wget-lua/releases/tag/v1.20.3-at.20200401.01
-				// https://iipc.github.io/warc-specifications/specifications/warc-zstd/
-				magic := uint32(0x184D2A5D)
-
-				// Create the frame header (magic + payload size)
-				header := make([]byte, 8)
-				binary.LittleEndian.PutUint32(header[:4], magic)
-				binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
-
-				// Combine header and payload together into a full frame.
-				frame := append(header, payload...)
-
-				// Write generated frame directly to WARC file.
-				// The regular ZStandard writer will continue afterwards with normal ZStandard frames.
-				writer.Write(frame)
+	algorithm, err := ParseCompressionAlgorithm(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterLevel(writer, fileName, algorithm, 0, newFileCreation, dictionary)
+}
+
+// NewWriterLevel creates a new WARC writer compressing with the given level of
+// the algorithm, 0 meaning its default level. The dictionary is only used with
+// ZSTD, and written at the start of the file when newFileCreation is true.
+func NewWriterLevel(writer io.Writer, fileName string, compression CompressionAlgorithm, level int, newFileCreation bool, dictionary []byte) (*Writer, error) {
+	switch compression {
+	case CompressionNone:
+		return &Writer{
+			FileName:    fileName,
+			underlying:  writer,
+			Compression: "",
+			FileWriter:  bufio.NewWriter(writer),
+		}, nil
+	case CompressionGZIP:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		gzipWriter, err := gzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Writer{
+			FileName:    fileName,
+			underlying:  writer,
+			Compression: string(compression),
+			GZIPWriter:  gzipWriter,
+			FileWriter:  bufio.NewWriter(gzipWriter),
+		}, nil
+	case CompressionZSTD:
+		encoderLevel := zstd.SpeedBetterCompression
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("invalid ZSTD compression level: %d", level)
 			}
 
-			// Create ZStandard writer either with or without the encoder dictionary and return it.
-			if len(dictionary) > 0 {
-				zstdWriter, err := zstd.NewWriter(writer, zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderDict(dictionary))
-				if err != nil {
-					return nil, err
-				}
-				return &Writer{
-					FileName:    fileName,
-					Compression: compression,
-					ZSTDWriter:  zstdWriter,
-					FileWriter:  bufio.NewWriter(zstdWriter),
-				}, nil
-			} else {
-				zstdWriter, err := zstd.NewWriter(writer, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
-				if err != nil {
-					return nil, err
-				}
-				return &Writer{
-					FileName:    fileName,
-					Compression: compression,
-					ZSTDWriter:  zstdWriter,
-					FileWriter:  bufio.NewWriter(zstdWriter),
-				}, nil
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+
+		if newFileCreation && len(dictionary) > 0 {
+			dictionaryZstdwriter, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+			if err != nil {
+				return nil, err
 			}
+
+			// Compress dictionary with ZSTD.
+			// TODO: Option to allow uncompressed dictionary (maybe? not sure there's any need.)
+			payload := dictionaryZstdwriter.EncodeAll(dictionary, nil)
+
+			// Magic number for skippable dictionary frame (0x184D2A5D).
+			// https://github.com/ArchiveTeam/wget-lua/releases/tag/v1.20.3-at.20200401.01
+			// https://iipc.github.io/warc-specifications/specifications/warc-zstd/
+			magic := uint32(0x184D2A5D)
+
+			// Create the frame header (magic + payload size)
+			header := make([]byte, 8)
+			binary.LittleEndian.PutUint32(header[:4], magic)
+			binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+
+			// Combine header and payload together into a full frame.
+			frame := append(header, payload...)
+
+			// Write generated frame directly to WARC file.
+			// The regular ZStandard writer will continue afterwards with normal ZStandard frames.
+			writer.Write(frame)
 		}
-		return nil, errors.New("invalid compression algorithm: " + compression)
-	}
 
-	return &Writer{
-		FileName:    fileName,
-		Compression: "",
-		FileWriter:  bufio.NewWriter(writer),
-	}, nil
+		// Create ZStandard writer either with or without the encoder dictionary and return it.
+		options := []zstd.EOption{zstd.WithEncoderLevel(encoderLevel)}
+		if len(dictionary) > 0 {
+			options = append(options, zstd.WithEncoderDict(dictionary))
+		}
+
+		zstdWriter, err := zstd.NewWriter(writer, options...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Writer{
+			FileName:    fileName,
+			underlying:  writer,
+			Compression: string(compression),
+			ZSTDWriter:  zstdWriter,
+			FileWriter:  bufio.NewWriter(zstdWriter),
+		}, nil
+	case CompressionXZ:
+		if level != 0 {
+			return nil, fmt.Errorf("XZ has no compression levels, got %d", level)
+		}
+
+		xzWriter, err := xz.NewWriter(writer)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Writer{
+			FileName:    fileName,
+			underlying:  writer,
+			Compression: string(compression),
+			XZWriter:    xzWriter,
+			FileWriter:  bufio.NewWriter(xzWriter),
+		}, nil
+	default:
+		return nil, errors.New("invalid compression algorithm: " + string(compression))
+	}
 }
 
 // NewRecord creates a new WARC record.
@@ -189,7 +345,7 @@ func checkRotatorSettings(settings *RotatorSettings) (err error) {
 	// Check if output directory is specified, if not, set it to the current directory
 	if settings.OutputDirectory == "" {
 		settings.OutputDirectory = "./"
-	} else {
+	} else if settings.Storage == nil {
 		// If it is specified, check if output directory exist
 		if _, err := os.Stat(settings.OutputDirectory); os.IsNotExist(err) {
 			// If it doesn't exist, create it
@@ -205,30 +361,108 @@ func checkRotatorSettings(settings *RotatorSettings) (err error) {
 		settings.WARCWriterPoolSize = 1
 	}
 
+	if settings.ParallelGZIP {
+		if settings.ParallelGZIPMinSize == 0 {
+			settings.ParallelGZIPMinSize = 1 << 20
+		}
+
+		if settings.ParallelGZIPBlockSize == 0 {
+			settings.ParallelGZIPBlockSize = 1 << 20
+		}
+
+		if settings.ParallelGZIPBlocks == 0 {
+			settings.ParallelGZIPBlocks = runtime.GOMAXPROCS(0)
+		}
+
+		// The block settings are checked now, rather than by every writer
+		err = pgzip.NewWriter(io.Discard).SetConcurrency(settings.ParallelGZIPBlockSize, settings.ParallelGZIPBlocks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.QueueSize == 0 {
+		settings.QueueSize = 1
+	}
+
+	if settings.SegmentSize < 0 {
+		return errors.New("SegmentSize can't be negative")
+	}
+
+	if settings.QueueSize < 0 || settings.PriorityQueueSize < 0 {
+		return errors.New("QueueSize and PriorityQueueSize can't be negative")
+	}
+
+	if settings.Metrics == nil {
+		settings.Metrics = noopMetrics{}
+	}
+
+	if settings.Logger == nil {
+		settings.Logger = slog.New(slog.DiscardHandler)
+	}
+
+	if settings.DataTotal == nil {
+		settings.DataTotal = new(ratecounter.Counter)
+	}
+
 	// Add a trailing slash to the output directory
 	if settings.OutputDirectory[len(settings.OutputDirectory)-1:] != "/" {
 		settings.OutputDirectory = settings.OutputDirectory + "/"
 	}
 
+	// Write to the output directory unless another storage is specified
+	if settings.Storage == nil {
+		settings.Storage = NewLocalStorage(settings.OutputDirectory)
+	}
+
 	// If prefix isn't specified, set it to "WARC"
 	if settings.Prefix == "" {
 		settings.Prefix = "WARC"
 	}
 
+	if settings.SyncPolicy < SyncNone || settings.SyncPolicy > SyncOnClose {
+		return fmt.Errorf("invalid sync policy: %d", settings.SyncPolicy)
+	}
+
+	if settings.SyncPolicy == SyncEveryBytes && settings.SyncBytes <= 0 {
+		return errors.New("SyncBytes must be set with the SyncEveryBytes policy")
+	}
+
+	if settings.FilenameTemplate == "" {
+		settings.FilenameTemplate = DefaultFilenameTemplate
+	}
+
+	if err := checkFilenameTemplate(settings.FilenameTemplate); err != nil {
+		return err
+	}
+
 	// If WARC size isn't specified, set it to 1GB (10^9 bytes) by default
 	if settings.WarcSize == 0 {
 		settings.WarcSize = 1000
 	}
 
-	// Check if the specified compression algorithm is valid
-	if settings.Compression != "" && settings.Compression != "GZIP" && settings.Compression != "ZSTD" {
-		return errors.New("invalid compression algorithm: " + settings.Compression)
+	// Check if the specified compression algorithm is valid, and use its canonical name
+	compression, err := ParseCompressionAlgorithm(settings.Compression)
+	if err != nil {
+		return err
+	}
+	settings.Compression = string(compression)
+
+	// Check the compression level by creating a writer with it
+	if _, err := NewWriterLevel(io.Discard, "", compression, settings.CompressionLevel, false, nil); err != nil {
+		return err
+	}
+
+	// Check if the WARC version is supported, and use its canonical name
+	settings.Version, err = ParseWARCVersion(string(settings.Version))
+	if err != nil {
+		return err
 	}
 
 	// Add few headers to the warcinfo payload, to not have it empty
 	settings.WarcinfoContent.Set("hostname", hostName)
-	settings.WarcinfoContent.Set("format", "WARC file version 1.1")
-	settings.WarcinfoContent.Set("conformsTo", "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/")
+	settings.WarcinfoContent.Set("format", settings.Version.Format())
+	settings.WarcinfoContent.Set("conformsTo", settings.Version.ConformsTo())
 
 	return nil
 }
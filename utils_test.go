@@ -1,7 +1,10 @@
 package warc
 
 import (
+	"bufio"
 	"bytes"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -56,21 +59,47 @@ func TestNewRotatorSettings(t *testing.T) {
 	}
 }
 
-// Tests for the isLineStartingWithHTTPMethod function
-func TestIsHTTPRequest(t *testing.T) {
-	goodHTTPRequestHeaders := []string{
-		"GET /index.html HTTP/1.1",
-		"POST /api/login HTTP/1.1",
-		"DELETE /api/products/456 HTTP/1.1",
-		"HEAD /about HTTP/1.0",
-		"OPTIONS / HTTP/1.1",
-		"PATCH /api/item/789 HTTP/1.1",
-		"GET /images/logo.png HTTP/1.1",
+func TestBuildWARCTargetURI(t *testing.T) {
+	testCases := []struct {
+		scheme   string
+		request  string
+		expected string
+	}{
+		{"http", "GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.com/index.html"},
+		{"http", "GET /?q=a%20b HTTP/1.1\r\nhost: Example.COM:80\r\n\r\n", "http://example.com/?q=a%20b"},
+		{"https", "GET / HTTP/1.1\r\nHOST: example.com:443\r\n\r\n", "https://example.com/"},
+		{"https", "GET / HTTP/1.1\r\nHost: example.com:8443\r\n\r\n", "https://example.com:8443/"},
+		{"http", "PROPFIND /dav/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.com/dav/"},
+		{"http", "GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n", "http://[::1]:8080/"},
+		{"http", "GET /a HTTP/1.1\r\nHost: [2001:DB8::1]:80\r\n\r\n", "http://[2001:db8::1]/a"},
+		{"http", "GET http://Example.com:80/page?x=1 HTTP/1.1\r\nHost: ignored.com\r\n\r\n", "http://example.com/page?x=1"},
+		{"http", "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n", "http://example.com:443"},
+		{"http", "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.com"},
 	}
 
-	for _, header := range goodHTTPRequestHeaders {
-		if !isHTTPRequest(header) {
-			t.Error("Invalid HTTP Method parsing:", header)
+	for _, tc := range testCases {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tc.request)))
+		if err != nil {
+			t.Fatalf("failed to read request %q: %s", tc.request, err)
 		}
+
+		uri, err := buildWARCTargetURI(tc.scheme, req)
+		if err != nil {
+			t.Errorf("buildWARCTargetURI failed for %q: %s", tc.request, err)
+			continue
+		}
+
+		if uri != tc.expected {
+			t.Errorf("unexpected WARC-Target-URI %s, expected %s", uri, tc.expected)
+		}
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = buildWARCTargetURI("http", req); err == nil {
+		t.Error("expected an error for a request without host")
 	}
 }
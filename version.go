@@ -0,0 +1,105 @@
+package warc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WARCVersion is the version of the WARC format written, as it appears on the
+// first line of every record. The differences between the two versions that
+// matter to the writer are:
+//   - WARC/1.0 dates (WARC-Date, WARC-Refers-To-Date) have a precision of one
+//     second, WARC/1.1 ones can have fractions of a second
+//   - the revisit profiles are identified by URIs specific to each version
+//   - the warcinfo records of the files conform to different specifications
+//
+// WARC-Refers-To-Target-URI and WARC-Refers-To-Date only are defined by
+// WARC/1.1, but WARC/1.0 readers must ignore the fields they don't know, so
+// they are written in both versions.
+type WARCVersion string
+
+const (
+	// WARCVersion10 is the version of ISO 28500:2009, still expected by some legacy tools
+	WARCVersion10 WARCVersion = "WARC/1.0"
+	// WARCVersion11 is the version of ISO 28500:2017, the default
+	WARCVersion11 WARCVersion = "WARC/1.1"
+)
+
+// ParseWARCVersion returns the version named name, with or without its "WARC/"
+// prefix, ignoring case. An empty name is WARCVersion11.
+func ParseWARCVersion(name string) (WARCVersion, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "WARC/") {
+	case "", "1.1":
+		return WARCVersion11, nil
+	case "1.0":
+		return WARCVersion10, nil
+	default:
+		return "", fmt.Errorf("unsupported WARC version: %s", name)
+	}
+}
+
+// orDefault returns v, or WARCVersion11 if it isn't set.
+func (v WARCVersion) orDefault() WARCVersion {
+	if v == "" {
+		return WARCVersion11
+	}
+
+	return v
+}
+
+// number returns the number of v, e.g. "1.1".
+func (v WARCVersion) number() string {
+	return strings.TrimPrefix(string(v.orDefault()), "WARC/")
+}
+
+// FormatDate formats t, in UTC, with the precision allowed by v.
+func (v WARCVersion) FormatDate(t time.Time) string {
+	if v.orDefault() == WARCVersion10 {
+		return t.UTC().Format("2006-01-02T15:04:05Z")
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// normalizeDate returns the date of a header field with the precision allowed
+// by v, it is returned as is if it can't be parsed.
+func (v WARCVersion) normalizeDate(date string) string {
+	if v.orDefault() != WARCVersion10 {
+		return date
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return date
+	}
+
+	return v.FormatDate(t)
+}
+
+// ConformsTo returns the URI of the specification of v, for the conformsTo
+// field of warcinfo records.
+func (v WARCVersion) ConformsTo() string {
+	if v.orDefault() == WARCVersion10 {
+		return "http://bibnum.bnf.fr/WARC/WARC_ISO_28500_version1_latestdraft.pdf"
+	}
+
+	return "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/"
+}
+
+// Format returns the description of v for the format field of warcinfo records.
+func (v WARCVersion) Format() string {
+	return "WARC file version " + v.number()
+}
+
+// revisitProfile returns the URI of a revisit profile, e.g.
+// "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest", in v.
+func (v WARCVersion) revisitProfile(profile string) string {
+	for _, version := range []WARCVersion{WARCVersion10, WARCVersion11} {
+		if name, found := strings.CutPrefix(profile, "http://netpreserve.org/warc/"+version.number()+"/revisit/"); found {
+			return "http://netpreserve.org/warc/" + v.number() + "/revisit/" + name
+		}
+	}
+
+	return profile
+}
@@ -0,0 +1,171 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWARCVersion(t *testing.T) {
+	for name, expected := range map[string]WARCVersion{
+		"":         WARCVersion11,
+		"1.1":      WARCVersion11,
+		"WARC/1.1": WARCVersion11,
+		"1.0":      WARCVersion10,
+		"warc/1.0": WARCVersion10,
+	} {
+		version, err := ParseWARCVersion(name)
+		if err != nil || version != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", name, expected, version, err)
+		}
+	}
+
+	if _, err := ParseWARCVersion("0.17"); err == nil {
+		t.Error("expected an error with an unsupported version")
+	}
+}
+
+func TestWARCVersionDates(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("", 3600))
+
+	if formatted := WARCVersion10.FormatDate(date); formatted != "2024-03-01T11:30:45Z" {
+		t.Errorf("expected a WARC/1.0 date with a precision of one second, got %s", formatted)
+	}
+
+	if formatted := WARCVersion11.FormatDate(date); formatted != "2024-03-01T11:30:45.123456789Z" {
+		t.Errorf("expected a WARC/1.1 date with nanoseconds, got %s", formatted)
+	}
+
+	if normalized := WARCVersion10.normalizeDate("2024-03-01T11:30:45.123Z"); normalized != "2024-03-01T11:30:45Z" {
+		t.Errorf("expected the fraction of second to be removed, got %s", normalized)
+	}
+
+	if normalized := WARCVersion11.normalizeDate("2024-03-01T11:30:45.123Z"); normalized != "2024-03-01T11:30:45.123Z" {
+		t.Errorf("expected WARC/1.1 dates to be kept, got %s", normalized)
+	}
+}
+
+func TestWriteRecordWARC10(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer, err := NewWriter(&buf, "test.warc", "", "", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Version = WARCVersion10
+
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "revisit")
+	record.Header.Set("WARC-Date", "2024-03-01T11:30:45.123456Z")
+	record.Header.Set("WARC-Refers-To-Date", "2024-02-01T10:00:00.5Z")
+	record.Header.Set("WARC-Profile", "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest")
+	record.Content.Write([]byte("revisit"))
+
+	if _, err := writer.WriteRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewReader(io.NopCloser(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, _, err := reader.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written.Content.Close()
+
+	for key, expected := range map[string]string{
+		"WARC-Date":           "2024-03-01T11:30:45Z",
+		"WARC-Refers-To-Date": "2024-02-01T10:00:00Z",
+		"WARC-Profile":        "http://netpreserve.org/warc/1.0/revisit/identical-payload-digest",
+	} {
+		if value := written.Header.Get(key); value != expected {
+			t.Errorf("%s: expected %s, got %s", key, expected, value)
+		}
+	}
+
+	if written.Version != "WARC/1.0" {
+		t.Errorf("expected version WARC/1.0, got %s", written.Version)
+	}
+}
+
+func TestRotatorWARC10(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.Version = "1.0"
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 1, 2, 100)
+	closeTestRotator(records, doneChannels)
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc.gz"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v (%v)", paths, err)
+	}
+
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+
+		if record.Version != "WARC/1.0" {
+			t.Errorf("expected version WARC/1.0, got %s", record.Version)
+		}
+
+		if date := record.Header.Get("WARC-Date"); strings.Contains(date, ".") {
+			t.Errorf("expected a WARC-Date with a precision of one second, got %s", date)
+		}
+
+		if record.Header.Get("WARC-Type") == "warcinfo" {
+			content, err := io.ReadAll(record.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(content), "conformsTo: "+WARCVersion10.ConformsTo()) || !strings.Contains(string(content), "format: WARC file version 1.0") {
+				t.Errorf("expected the warcinfo to conform to WARC/1.0, got %q", content)
+			}
+		}
+
+		record.Content.Close()
+	}
+
+	if count != 3 {
+		t.Errorf("expected warcinfo and 2 records, got %d records", count)
+	}
+}
+
+func TestRotatorInvalidWARCVersion(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.Version = "2.0"
+
+	if _, _, err := rotatorSettings.NewWARCRotator(); err == nil {
+		t.Fatal("expected an error with an unsupported WARC version")
+	}
+}
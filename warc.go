@@ -1,14 +1,20 @@
 package warc
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"hash"
+	"io"
+	"log/slog"
 	"os"
-	"path"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
 
 	"github.com/paulbellamy/ratecounter"
+	"github.com/ulikunitz/xz"
 )
 
 // RotatorSettings is used to store the settings
@@ -17,98 +23,373 @@ type RotatorSettings struct {
 	// Content of the warcinfo record that will be written
 	// to all WARC files
 	WarcinfoContent Header
+	// Version is the version of the WARC files written, "1.0" or "1.1" (the
+	// default), see WARCVersion. It sets the version line of the records, the
+	// precision of their dates and the format and conformsTo of the warcinfo
+	Version WARCVersion
 	// Prefix used for WARC filenames, WARC 1.1 specifications
 	// recommend to name files this way:
 	// Prefix-Timestamp-Serial-Crawlhost.warc.gz
 	Prefix string
-	// Compression algorithm to use
+	// FilenameTemplate is the name of the WARC files, without extension, with
+	// tokens replaced for every file, see filenameToken in file.go. Default is
+	// DefaultFilenameTemplate
+	FilenameTemplate string
+	// SerialFile is the path of a file where the last serial is saved, so that
+	// a rotator started again keeps numbering its files forward. Serials never
+	// wrap, and aren't persisted when it's empty
+	SerialFile string
+	// RecoverOpenFiles makes NewWARCRotator recover the .open files left in the
+	// output directory by a crash before writing, see RecoverWARCFiles. It must
+	// not be set if other rotators write to the same directory, and is only
+	// supported with a LocalStorage
+	RecoverOpenFiles bool
+	// SyncPolicy tells when the WARC files are fsynced, and so when the
+	// FeedbackChan of a batch is signalled, see SyncPolicy. Finalized files are
+	// always synced, with their directory, unless it is SyncNone
+	SyncPolicy SyncPolicy
+	// SyncBytes is the number of bytes written after which files are synced
+	// with the SyncEveryBytes policy
+	SyncBytes int64
+	// Compression algorithm to use, the name of a CompressionAlgorithm, case-insensitive
 	Compression string
+	// CompressionLevel is the level of the compression algorithm, see
+	// CompressionAlgorithm. 0 means the default level of the algorithm
+	CompressionLevel int
+	// ParallelGZIP compresses the records of at least ParallelGZIPMinSize bytes
+	// (default 1MB) with GZIP by blocks of ParallelGZIPBlockSize bytes (default
+	// 1MB), up to ParallelGZIPBlocks (default GOMAXPROCS) being compressed in
+	// parallel. Every record still is a single GZIP member
+	ParallelGZIP          bool
+	ParallelGZIPMinSize   int64
+	ParallelGZIPBlockSize int
+	ParallelGZIPBlocks    int
 	// Path to a ZSTD compression dictionary to embed (and use) in .warc.zst files
 	CompressionDictionary string
 	// Directory where the created WARC files will be stored,
 	// default will be the current directory
 	OutputDirectory string
+	// Storage is where the WARC files are written, default is a LocalStorage
+	// writing to OutputDirectory, which is ignored when Storage is set
+	Storage Storage
 	// WarcSize is in Megabytes
 	WarcSize float64
+	// WarcMaxBytes is the exact size in bytes a WARC file never exceeds: it is
+	// rotated before a record would take it above it, unless the record is the
+	// only one of the file. It takes precedence over WarcSize when set
+	WarcMaxBytes int64
+	// WarcMaxRecords is the number of records (warcinfo excluded) after which a
+	// WARC file is rotated. Records of a batch are never split between files, so
+	// a file can hold slightly more records. 0 means no limit
+	WarcMaxRecords int
+	// WarcMaxAge is the time after which a WARC file is rotated, even if no more
+	// records are written to it. Files without records are never rotated. 0 means no limit
+	WarcMaxAge time.Duration
+	// SegmentSize is the size in bytes above which the block of a record is
+	// split in segments: the record is written with WARC-Segment-Number 1 and
+	// the rest of its block in continuation records, which can be written to
+	// the next files when the current one exceeds its size. Readers reassemble
+	// them with ReadReassembledRecord. 0 means records are never segmented
+	SegmentSize int64
 	// WARCWriterPoolSize defines the number of parallel WARC writers
 	WARCWriterPoolSize int
+	// QueueSize is the number of batches that can wait for a writer before
+	// senders block, default is 1
+	QueueSize int
+	// PriorityQueueSize enables, when positive, a priority lane of that size:
+	// batches sent to it with SendBatch are written before those of the
+	// channel returned by NewWARCRotator. A client sends the records of
+	// WriteRecord, e.g. DNS records, to it
+	PriorityQueueSize int
+	// MaxWriteBytesPerSecond and MaxWriteOpsPerSecond limit the bytes and write
+	// operations per second of all the writers of the rotator together, and
+	// MaxWriterBytesPerSecond and MaxWriterOpsPerSecond those of each writer.
+	// Throttled writers hold back the clients sending records to the rotator.
+	// 0 means no limit
+	MaxWriteBytesPerSecond  int64
+	MaxWriteOpsPerSecond    int
+	MaxWriterBytesPerSecond int64
+	MaxWriterOpsPerSecond   int
+	// Metrics receives the measurements of the writers, see metrics.go.
+	// A client sets it to its own Metrics if it is nil.
+	Metrics Metrics
+	// Logger receives debug-level traces of the creation, rotation and closing of
+	// WARC files. A client sets it to its own Logger if it is nil.
+	Logger *slog.Logger
+	// DataTotal counts the bytes of record content written to WARC files.
+	// A client sets it to its own DataTotal if it is nil.
+	DataTotal *ratecounter.Counter
+	// OnFileOpened is called by a writer once it created a WARC file and wrote
+	// its warcinfo record. Path still has the .open suffix, Size, Records and
+	// SHA256 aren't set yet
+	OnFileOpened func(WARCFileInfo)
+	// OnFileClosed is called by a writer once a WARC file is finalized (closed
+	// and renamed), e.g. to upload or index it. Hooks run on the writer
+	// goroutine and delay the writing of the next records until they return
+	OnFileClosed func(WARCFileInfo)
+
+	// rotate and stopped are used by Rotate to reach the writers, one channel per writer
+	rotate  []chan chan struct{}
+	stopped []chan struct{}
+	// records and priority are the queues of the writers, priority is nil when disabled
+	records  chan *RecordBatch
+	priority chan *RecordBatch
+	// bytesLimiter and opsLimiter enforce the limits shared by the writers, nil without limit
+	bytesLimiter *rateLimiter
+	opsLimiter   *rateLimiter
 }
 
-var (
-	// Create mutex to ensure we are generating WARC files one at a time and not naming them the same thing.
-	fileMutex sync.Mutex
-
-	// Create a counter to keep track of the number of bytes written to WARC files
-	// and the number of bytes deduped
-	DataTotal         *ratecounter.Counter
-	RemoteDedupeTotal *ratecounter.Counter
-	LocalDedupeTotal  *ratecounter.Counter
-)
-
-func init() {
-	// Initialize the counters
-	DataTotal = new(ratecounter.Counter)
-	RemoteDedupeTotal = new(ratecounter.Counter)
-	LocalDedupeTotal = new(ratecounter.Counter)
+// WARCFileInfo describes a WARC file written by a rotator.
+type WARCFileInfo struct {
+	// Path of the file, in the output directory, or its name in the storage
+	// when it isn't a LocalStorage
+	Path string
+	// Size of the file in bytes
+	Size int64
+	// Records is the number of records of the file, warcinfo excluded
+	Records int
+	// SHA256 is the hex-encoded SHA-256 digest of the file
+	SHA256 string
+	// WarcinfoID is the WARC-Record-ID of the warcinfo record of the file
+	WarcinfoID string
 }
 
 // NewWARCRotator creates and return a channel that can be used
 // to communicate records to be written to WARC files to the
 // recordWriter function running in a goroutine
 func (s *RotatorSettings) NewWARCRotator() (recordWriterChan chan *RecordBatch, doneChannels []chan bool, err error) {
-	recordWriterChan = make(chan *RecordBatch, 1)
-
-	// Create global atomicSerial number for numbering WARC files.
-	var serial = new(atomic.Uint64)
-
 	// Check the rotator settings and set default values
 	err = checkRotatorSettings(s)
 	if err != nil {
 		return recordWriterChan, doneChannels, err
 	}
 
+	recordWriterChan = make(chan *RecordBatch, s.QueueSize)
+	s.records = recordWriterChan
+
+	if s.PriorityQueueSize > 0 {
+		s.priority = make(chan *RecordBatch, s.PriorityQueueSize)
+	}
+
+	if s.RecoverOpenFiles {
+		localStorage, ok := s.Storage.(*LocalStorage)
+		if !ok {
+			return recordWriterChan, doneChannels, errors.New("RecoverOpenFiles is only supported with a LocalStorage")
+		}
+
+		recovered, err := RecoverWARCFiles(localStorage.Directory, true)
+		if err != nil {
+			return recordWriterChan, doneChannels, err
+		}
+
+		for _, file := range recovered {
+			s.Logger.Debug("recovered WARC file", "file", file.OpenPath, "records", file.Records, "truncatedBytes", file.TruncatedBytes, "removed", file.Removed)
+		}
+	}
+
+	// Create the serial numbering the WARC files of all writers
+	serial, err := newSerialCounter(s.SerialFile)
+	if err != nil {
+		return recordWriterChan, doneChannels, err
+	}
+
+	s.bytesLimiter = newRateLimiter(float64(s.MaxWriteBytesPerSecond))
+	s.opsLimiter = newRateLimiter(float64(s.MaxWriteOpsPerSecond))
+
+	s.rotate = make([]chan chan struct{}, s.WARCWriterPoolSize)
+	s.stopped = make([]chan struct{}, s.WARCWriterPoolSize)
+
 	for i := 0; i < s.WARCWriterPoolSize; i++ {
 		doneChan := make(chan bool)
 		doneChannels = append(doneChannels, doneChan)
 
-		go recordWriter(s, recordWriterChan, doneChan, serial)
+		s.rotate[i] = make(chan chan struct{})
+		s.stopped[i] = make(chan struct{})
+
+		go recordWriter(s, recordWriterChan, s.priority, doneChan, s.rotate[i], s.stopped[i], serial, i)
 	}
 
 	return recordWriterChan, doneChannels, nil
 }
 
+// SendBatch queues batch for the writers of the rotator, on the priority lane if
+// priority is true and the lane is enabled, see PriorityQueueSize. It blocks
+// while the queue is full, and returns the error of ctx if it is done first.
+// It must not be called once the channel returned by NewWARCRotator is closed.
+func (s *RotatorSettings) SendBatch(ctx context.Context, batch *RecordBatch, priority bool) error {
+	queue := s.records
+	if priority && s.priority != nil {
+		queue = s.priority
+	}
+
+	batch.queuedAt = time.Now()
+
+	select {
+	case queue <- batch:
+		// The depth is also set when enqueueing, so that it shows the batches piling up behind a slow write
+		s.Metrics.SetQueueDepth(len(s.records) + len(s.priority))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Rotate closes the current WARC file of every writer of the rotator, unless
+// no record was written to it, and replaces it by a new one. It returns once
+// the files are closed and renamed, so that they can be handed over.
+func (s *RotatorSettings) Rotate() {
+	for i := range s.rotate {
+		rotated := make(chan struct{})
+
+		select {
+		case s.rotate[i] <- rotated:
+			<-rotated
+		case <-s.stopped[i]:
+		}
+	}
+}
+
+// filePath returns the path of the WARC file name of the storage: its path
+// in the output directory for a LocalStorage, else its name.
+func (s *RotatorSettings) filePath(name string) string {
+	if localStorage, ok := s.Storage.(*LocalStorage); ok {
+		return localStorage.Path(name)
+	}
+
+	return name
+}
+
+// maxFileSize returns the size in bytes above which a WARC file is rotated.
+func (s *RotatorSettings) maxFileSize() int64 {
+	if s.WarcMaxBytes > 0 {
+		return s.WarcMaxBytes
+	}
+
+	return int64(s.WarcSize * 1024 * 1024)
+}
+
+// useParallelGZIP tells if record is large enough to be compressed with ParallelGZIP.
+func (s *RotatorSettings) useParallelGZIP(record *Record) bool {
+	if !s.ParallelGZIP || s.Compression != "GZIP" {
+		return false
+	}
+
+	contentLength, err := strconv.ParseInt(record.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		contentLength = int64(getContentLength(record.Content))
+	}
+
+	return contentLength >= s.ParallelGZIPMinSize
+}
+
 func (w *Writer) CloseCompressedWriter() (err error) {
 	if w.GZIPWriter != nil {
 		err = w.GZIPWriter.Close()
+	} else if w.ParallelGZIPWriter != nil {
+		err = w.ParallelGZIPWriter.Close()
 	} else if w.ZSTDWriter != nil {
 		err = w.ZSTDWriter.Close()
+	} else if w.XZWriter != nil {
+		// Unlike the other writers, the XZ writer fails when closed twice
+		err = w.XZWriter.Close()
+		w.XZWriter = nil
 	}
 
 	return err
 }
 
-func recordWriter(settings *RotatorSettings, records chan *RecordBatch, done chan bool, serial *atomic.Uint64) {
-	var (
-		currentFileName         = generateWarcFileName(settings.Prefix, settings.Compression, serial)
-		currentWarcinfoRecordID string
-	)
+// resetCompressedWriter starts a new compressed stream, after the previous one
+// was closed by CloseCompressedWriter, to the same underlying writer and with
+// the same settings, reusing the encoder where the algorithm allows it.
+func (w *Writer) resetCompressedWriter() error {
+	switch {
+	case w.GZIPWriter != nil:
+		w.GZIPWriter.Reset(w.underlying)
+	case w.ParallelGZIPWriter != nil:
+		w.ParallelGZIPWriter.Reset(w.underlying)
+	case w.ZSTDWriter != nil:
+		w.ZSTDWriter.Reset(w.underlying)
+	case w.Compression == string(CompressionXZ):
+		xzWriter, err := xz.NewWriter(w.underlying)
+		if err != nil {
+			return err
+		}
+
+		w.XZWriter = xzWriter
+		w.FileWriter.Reset(xzWriter)
+	}
+
+	return nil
+}
+
+// recordSizeBound returns an upper bound of the size of record once written by
+// WriteRecord, so that the file can be rotated before writing it: its size
+// uncompressed, counting the fields WriteRecord may add, and when compressed the
+// framing overhead of the algorithms on data that doesn't compress.
+func recordSizeBound(record *Record, version WARCVersion, compressed bool) int64 {
+	size := int64(len(version.orDefault())) + 2
 
-	// Ensure file doesn't already exist (and if it does, make a new one)
-	fileMutex.Lock()
-	_, err := os.Stat(settings.OutputDirectory + currentFileName)
-	for !errors.Is(err, os.ErrNotExist) {
-		currentFileName = generateWarcFileName(settings.Prefix, settings.Compression, serial)
-		_, err = os.Stat(settings.OutputDirectory + currentFileName)
+	for key, value := range record.Header {
+		size += int64(len(key)+len(value)) + 4
 	}
 
-	// Create and open the initial file
-	warcFile, err := os.Create(settings.OutputDirectory + currentFileName)
+	// None of these fields is longer than 96 bytes
+	for _, key := range []string{"WARC-Date", "WARC-Type", "WARC-Record-ID", "Content-Length", "WARC-Block-Digest"} {
+		if record.Header.Get(key) == "" {
+			size += 96
+		}
+	}
+
+	length, err := strconv.ParseInt(record.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
-		panic(err)
+		length = int64(getContentLength(record.Content))
+	}
+
+	// The end of the header and the record boundary
+	size += length + 6
+
+	if compressed {
+		size += size/16384*5 + 64
 	}
-	fileMutex.Unlock()
 
-	var dictionary []byte
+	return size
+}
+
+// createWARCFile creates a new WARC file in the storage, generating names
+// until one that doesn't exist yet is found.
+func createWARCFile(settings *RotatorSettings, serial *serialCounter, writerID int) (warcFile StorageFile, fileName string, err error) {
+	for {
+		fileName, err = generateWarcFileName(settings, serial, writerID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		warcFile, err = settings.Storage.Create(fileName)
+		if !errors.Is(err, os.ErrExist) {
+			return warcFile, fileName, err
+		}
+	}
+}
+
+func recordWriter(settings *RotatorSettings, records, priority chan *RecordBatch, done chan bool, rotate chan chan struct{}, stopped chan struct{}, serial *serialCounter, writerID int) {
+	var (
+		currentFileName         string
+		currentWarcinfoRecordID string
+		currentRecordCount      int
+		warcFile                StorageFile
+		throttle                *throttledWriter
+		fileWriter              io.Writer
+		fileHash                hash.Hash
+		warcWriter              *Writer
+		dictionary              []byte
+		ageTimer                *time.Timer
+		ageTimerChan            <-chan time.Time
+		pendingFeedback         []chan struct{}
+		unsyncedBytes           int64
+		err                     error
+	)
+
+	defer close(stopped)
 
 	if settings.CompressionDictionary != "" {
 		dictionary, err = os.ReadFile(settings.CompressionDictionary)
@@ -117,95 +398,195 @@ func recordWriter(settings *RotatorSettings, records chan *RecordBatch, done cha
 		}
 	}
 
-	// Initialize WARC writer
-	warcWriter, err := NewWriter(warcFile, currentFileName, settings.Compression, "", true, dictionary)
-	if err != nil {
-		panic(err)
+	// The writer is limited by the limits of the rotator and its own
+	throttle = newThrottledWriter(
+		[]*rateLimiter{settings.bytesLimiter, newRateLimiter(float64(settings.MaxWriterBytesPerSecond))},
+		[]*rateLimiter{settings.opsLimiter, newRateLimiter(float64(settings.MaxWriterOpsPerSecond))},
+	)
+
+	// openFile creates a new WARC file and writes its info record
+	openFile := func() {
+		warcFile, currentFileName, err = createWARCFile(settings, serial, writerID)
+		if err != nil {
+			panic(err)
+		}
+
+		settings.Logger.Debug("created WARC file", "writer", writerID, "file", currentFileName)
+
+		// The file is hashed as it is written, for OnFileClosed
+		fileHash = sha256.New()
+		throttle.file = warcFile
+		fileWriter = io.MultiWriter(throttle, fileHash)
+
+		// Initialize WARC writer
+		warcWriter, err = NewWriterLevel(fileWriter, currentFileName, CompressionAlgorithm(settings.Compression), settings.CompressionLevel, true, dictionary)
+		if err != nil {
+			panic(err)
+		}
+		warcWriter.Version = settings.Version
+
+		// Write the info record
+		currentWarcinfoRecordID, err = warcWriter.WriteInfoRecord(settings.WarcinfoContent)
+		if err != nil {
+			panic(err)
+		}
+
+		// If compression is enabled, we close the record's GZIP chunk
+		if settings.Compression != "" {
+			err = warcWriter.CloseCompressedWriter()
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		currentRecordCount = 0
+
+		if settings.OnFileOpened != nil {
+			settings.OnFileOpened(WARCFileInfo{
+				Path:       settings.filePath(currentFileName),
+				WarcinfoID: "<urn:uuid:" + currentWarcinfoRecordID + ">",
+			})
+		}
+
+		if settings.WarcMaxAge > 0 {
+			if ageTimer == nil {
+				ageTimer = time.NewTimer(settings.WarcMaxAge)
+				ageTimerChan = ageTimer.C
+			} else {
+				ageTimer.Reset(settings.WarcMaxAge)
+			}
+		}
 	}
 
-	// Write the info record
-	currentWarcinfoRecordID, err = warcWriter.WriteInfoRecord(settings.WarcinfoContent)
-	if err != nil {
-		panic(err)
+	// signalFeedback tells the senders of the batches written so far that their records are durable
+	signalFeedback := func() {
+		for _, feedbackChan := range pendingFeedback {
+			feedbackChan <- struct{}{}
+			close(feedbackChan)
+		}
+
+		pendingFeedback = nil
 	}
 
-	// If compression is enabled, we close the record's GZIP chunk
-	if settings.Compression != "" {
-		err = warcWriter.CloseCompressedWriter()
+	// syncFile makes the records written to the current WARC file durable
+	syncFile := func() {
+		err := warcFile.Sync()
+		if err != nil {
+			panic(err)
+		}
+
+		unsyncedBytes = 0
+		signalFeedback()
+	}
+
+	// closeFile flushes the data, closes the current WARC file and finalizes it without the .open suffix
+	closeFile := func() {
+		warcWriter.FileWriter.Flush()
+		if settings.Compression != "" {
+			err = warcWriter.CloseCompressedWriter()
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		if settings.SyncPolicy != SyncNone {
+			err = warcFile.Sync()
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		fileSize := warcFile.Size()
+
+		err = warcFile.Close()
 		if err != nil {
 			panic(err)
 		}
 
-		warcWriter, err = NewWriter(warcFile, currentFileName, settings.Compression, "", false, dictionary)
+		err = settings.Storage.Finalize(currentFileName, strings.TrimSuffix(currentFileName, ".open"), settings.SyncPolicy != SyncNone)
 		if err != nil {
 			panic(err)
 		}
+
+		unsyncedBytes = 0
+		signalFeedback()
+
+		settings.Logger.Debug("closed WARC file", "writer", writerID, "file", strings.TrimSuffix(currentFileName, ".open"), "records", currentRecordCount)
+
+		if settings.OnFileClosed != nil {
+			settings.OnFileClosed(WARCFileInfo{
+				Path:       settings.filePath(strings.TrimSuffix(currentFileName, ".open")),
+				Size:       fileSize,
+				Records:    currentRecordCount,
+				SHA256:     hex.EncodeToString(fileHash.Sum(nil)),
+				WarcinfoID: "<urn:uuid:" + currentWarcinfoRecordID + ">",
+			})
+		}
 	}
 
-	for {
-		recordBatch, more := <-records
-		if more {
-			if isFileSizeExceeded(warcFile, settings.WarcSize) {
-				// WARC file size exceeded settings.WarcSize
-				// The WARC file is renamed to remove the .open suffix
-				err := os.Rename(path.Join(settings.OutputDirectory, currentFileName), strings.TrimSuffix(path.Join(settings.OutputDirectory, currentFileName), ".open"))
-				if err != nil {
-					panic(err)
-				}
+	// rotateFile replaces the current WARC file by a new one, unless no record was written to it
+	rotateFile := func(reason string) {
+		if currentRecordCount == 0 {
+			return
+		}
 
-				// We flush the data and close the file
-				warcWriter.FileWriter.Flush()
-				if settings.Compression != "" {
-					err = warcWriter.CloseCompressedWriter()
-					if err != nil {
-						panic(err)
-					}
-				}
+		settings.Logger.Debug("rotating WARC file", "writer", writerID, "file", strings.TrimSuffix(currentFileName, ".open"), "reason", reason)
 
-				err = warcFile.Close()
-				if err != nil {
-					panic(err)
-				}
+		closeFile()
+		openFile()
+	}
 
-				// Create the new file and automatically increment the serial inside of GenerateWarcFileName
-				currentFileName = generateWarcFileName(settings.Prefix, settings.Compression, serial)
-				warcFile, err = os.Create(settings.OutputDirectory + currentFileName)
-				if err != nil {
-					panic(err)
-				}
+	// writeBatch writes the records of a batch to the current WARC file, lane is
+	// the queue it was taken from
+	writeBatch := func(recordBatch *RecordBatch, lane string) {
+		settings.Metrics.SetQueueDepth(len(records) + len(priority))
+		if !recordBatch.queuedAt.IsZero() {
+			settings.Metrics.ObserveQueueWait(lane, time.Since(recordBatch.queuedAt))
+		}
 
-				// Initialize new WARC writer
-				warcWriter, err = NewWriter(warcFile, currentFileName, settings.Compression, "", true, dictionary)
-				if err != nil {
-					panic(err)
-				}
+		// The records of a batch are always written to the same file, so their number is checked before writing it
+		if settings.WarcMaxRecords > 0 && currentRecordCount >= settings.WarcMaxRecords {
+			rotateFile("records")
+		} else if isFileSizeExceeded(warcFile, settings.maxFileSize()) {
+			rotateFile("size")
+		}
 
-				// Write the info record
-				currentWarcinfoRecordID, err = warcWriter.WriteInfoRecord(settings.WarcinfoContent)
-				if err != nil {
-					panic(err)
-				}
+		batchStart := warcFile.Size()
+		// batchBytes are the bytes of the batch written to the files rotated while writing it
+		var batchBytes int64
 
-				// If compression is enabled, we close the record's GZIP chunk
-				if settings.Compression != "" {
-					err = warcWriter.CloseCompressedWriter()
-					if err != nil {
-						panic(err)
-					}
-				}
+		// Write all the records of the record batch
+		for _, record := range recordBatch.Records {
+			record.Header.Set("WARC-Date", recordBatch.CaptureTime)
+
+			if spooled, ok := record.Content.(interface{ FileName() string }); ok && spooled.FileName() != "" {
+				settings.Metrics.IncSpooledToDisk()
 			}
 
-			// Write all the records of the record batch
-			for _, record := range recordBatch.Records {
-				warcWriter, err = NewWriter(warcFile, currentFileName, settings.Compression, record.Header.Get("Content-Length"), false, dictionary)
+			segments := segmentRecord(record, settings.SegmentSize)
+
+			for _, segment := range segments {
+				// The file is rotated before a record would take it above its maximum size,
+				// so only a file holding a single record can exceed it
+				if currentRecordCount > 0 && warcFile.Size()+recordSizeBound(segment, settings.Version, settings.Compression != "") > settings.maxFileSize() {
+					batchBytes += warcFile.Size() - batchStart
+					rotateFile("size")
+					batchStart = warcFile.Size()
+				}
+
+				if settings.useParallelGZIP(segment) {
+					warcWriter, err = NewParallelGZIPWriter(fileWriter, currentFileName, settings.CompressionLevel, settings.ParallelGZIPBlockSize, settings.ParallelGZIPBlocks)
+				} else {
+					warcWriter, err = NewWriterLevel(fileWriter, currentFileName, CompressionAlgorithm(settings.Compression), settings.CompressionLevel, false, dictionary)
+				}
 				if err != nil {
 					panic(err)
 				}
+				warcWriter.Version = settings.Version
 
-				record.Header.Set("WARC-Date", recordBatch.CaptureTime)
-				record.Header.Set("WARC-Warcinfo-ID", "<urn:uuid:"+currentWarcinfoRecordID+">")
+				segment.Header.Set("WARC-Warcinfo-ID", "<urn:uuid:"+currentWarcinfoRecordID+">")
 
-				_, err := warcWriter.WriteRecord(record)
+				_, err := warcWriter.WriteRecord(segment)
 				if err != nil {
 					panic(err)
 				}
@@ -217,42 +598,98 @@ func recordWriter(settings *RotatorSettings, records chan *RecordBatch, done cha
 						panic(err)
 					}
 				}
-			}
 
-			err = warcWriter.FileWriter.Flush()
-			if err != nil {
-				panic(err)
-			}
+				currentRecordCount++
 
-			if recordBatch.FeedbackChan != nil {
-				recordBatch.FeedbackChan <- struct{}{}
-				close(recordBatch.FeedbackChan)
-			}
-		} else {
-			// Channel has been closed
-			// We flush the data, close the file, and rename it
-			warcWriter.FileWriter.Flush()
-			if settings.Compression != "" {
-				err = warcWriter.CloseCompressedWriter()
-				if err != nil {
-					panic(err)
+				if contentLength, err := strconv.ParseInt(segment.Header.Get("Content-Length"), 10, 64); err == nil {
+					settings.DataTotal.Incr(contentLength)
 				}
+
+				settings.Metrics.IncRecordsWritten(segment.Header.Get("WARC-Type"))
 			}
 
-			err = warcFile.Close()
-			if err != nil {
-				panic(err)
+			// The segments don't close the content they share
+			if len(segments) > 1 {
+				record.Content.Close()
 			}
+		}
 
-			// The WARC file is renamed to remove the .open suffix
-			err := os.Rename(settings.OutputDirectory+currentFileName, strings.TrimSuffix(settings.OutputDirectory+currentFileName, ".open"))
-			if err != nil {
-				panic(err)
+		err = warcWriter.FileWriter.Flush()
+		if err != nil {
+			panic(err)
+		}
+
+		batchEnd := warcFile.Size()
+		settings.Metrics.AddBytesWritten(writerID, batchBytes+batchEnd-batchStart)
+
+		if throttle.throttled > 0 {
+			settings.Metrics.AddThrottledDuration(writerID, throttle.throttled)
+			settings.Logger.Debug("throttled WARC writer", "writer", writerID, "duration", throttle.throttled)
+			throttle.throttled = 0
+		}
+
+		// The feedback is signalled once the batch is durable under the sync policy,
+		// except with SyncOnClose for the senders which only wait for it to be written
+		if recordBatch.FeedbackChan != nil && recordBatch.feedbackOnWrite && settings.SyncPolicy == SyncOnClose {
+			recordBatch.FeedbackChan <- struct{}{}
+			close(recordBatch.FeedbackChan)
+		} else if recordBatch.FeedbackChan != nil {
+			pendingFeedback = append(pendingFeedback, recordBatch.FeedbackChan)
+		}
+
+		unsyncedBytes += batchEnd - batchStart
+
+		switch settings.SyncPolicy {
+		case SyncNone:
+			signalFeedback()
+		case SyncEveryBatch:
+			syncFile()
+		case SyncEveryBytes:
+			if unsyncedBytes >= settings.SyncBytes || len(records)+len(priority) == 0 {
+				syncFile()
 			}
+		}
+	}
 
-			done <- true
+	openFile()
 
-			return
+	for {
+		// Batches of the priority lane are written first
+		select {
+		case recordBatch := <-priority:
+			writeBatch(recordBatch, "priority")
+			continue
+		default:
+		}
+
+		select {
+		case recordBatch := <-priority:
+			writeBatch(recordBatch, "priority")
+		case recordBatch, more := <-records:
+			if !more {
+				// Channel has been closed, the batches left in the priority lane are written first
+				for len(priority) > 0 {
+					writeBatch(<-priority, "priority")
+				}
+
+				closeFile()
+
+				done <- true
+
+				return
+			}
+
+			writeBatch(recordBatch, "default")
+		case <-ageTimerChan:
+			if currentRecordCount == 0 {
+				// Nothing to close, the age of the file is checked again later
+				ageTimer.Reset(settings.WarcMaxAge)
+			} else {
+				rotateFile("age")
+			}
+		case rotated := <-rotate:
+			rotateFile("forced")
+			close(rotated)
 		}
 	}
 }
@@ -0,0 +1,694 @@
+package warc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestBatches sends batches of records to a rotator, waiting for each batch to be written.
+func writeTestBatches(t *testing.T, records chan *RecordBatch, batches, recordsPerBatch, contentSize int) {
+	for i := 0; i < batches; i++ {
+		batch := NewRecordBatch(make(chan struct{}, 1))
+
+		for j := 0; j < recordsPerBatch; j++ {
+			record := NewRecord("", false)
+			record.Header.Set("WARC-Type", "resource")
+			record.Header.Set("WARC-Target-URI", "http://example.com/")
+			if _, err := record.Content.Write([]byte(strings.Repeat("a", contentSize))); err != nil {
+				t.Fatal(err)
+			}
+
+			batch.Records = append(batch.Records, record)
+		}
+
+		records <- batch
+		<-batch.FeedbackChan
+	}
+}
+
+// closeTestRotator closes a rotator and waits for its writers to be done.
+func closeTestRotator(records chan *RecordBatch, doneChannels []chan bool) {
+	close(records)
+	for _, done := range doneChannels {
+		<-done
+	}
+}
+
+// countRecordsPerFile returns the sorted numbers of records, warcinfo excluded, of the closed WARC files of dir.
+func countRecordsPerFile(t *testing.T, dir string) (counts []int) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.warc*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range files {
+		if strings.HasSuffix(path, ".open") {
+			continue
+		}
+
+		counts = append(counts, countFileRecords(t, path))
+	}
+
+	slices.Sort(counts)
+
+	return counts
+}
+
+// countFileRecords returns the number of records, warcinfo excluded, of the WARC file at path.
+func countFileRecords(t *testing.T, path string) (count int) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			return count
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Header.Get("WARC-Type") != "warcinfo" {
+			count++
+		}
+		record.Content.Close()
+	}
+}
+
+func TestRotatorMaxRecords(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.WarcMaxRecords = 2
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 5, 1, 10)
+	closeTestRotator(records, doneChannels)
+
+	counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory)
+	if !slices.Equal(counts, []int{1, 2, 2}) {
+		t.Errorf("expected files of 2, 2 and 1 records, got %v", counts)
+	}
+}
+
+func TestRotatorMaxBytes(t *testing.T) {
+	for name, setup := range map[string]func(*RotatorSettings){
+		"WarcMaxBytes":        func(s *RotatorSettings) { s.WarcMaxBytes = 2048 },
+		"fractional WarcSize": func(s *RotatorSettings) { s.WarcSize = 0.002 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			rotatorSettings := defaultRotatorSettings(t)
+			rotatorSettings.Compression = ""
+			setup(rotatorSettings)
+
+			records, doneChannels, err := rotatorSettings.NewWARCRotator()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only 2 records fit in 2KB with the warcinfo, so batches are split between files,
+			// and the last record, larger than 2KB, gets its own file
+			writeTestBatches(t, records, 2, 3, 400)
+			writeTestBatches(t, records, 1, 1, 3000)
+			closeTestRotator(records, doneChannels)
+
+			counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory)
+			if !slices.Equal(counts, []int{1, 2, 2, 2}) {
+				t.Errorf("expected 3 files of 2 records and 1 of 1 record, got %v", counts)
+			}
+
+			checkMaxFileSize(t, rotatorSettings.OutputDirectory, 2048)
+		})
+	}
+}
+
+func TestRotatorMaxBytesCompressed(t *testing.T) {
+	for _, compression := range []string{"GZIP", "ZSTD", "XZ"} {
+		t.Run(compression, func(t *testing.T) {
+			rotatorSettings := defaultRotatorSettings(t)
+			rotatorSettings.Compression = compression
+			rotatorSettings.WarcMaxBytes = 8192
+
+			records, doneChannels, err := rotatorSettings.NewWARCRotator()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Random content doesn't compress, so the records are larger once compressed
+			for i := 0; i < 20; i++ {
+				content := make([]byte, 1500)
+				rand.Read(content)
+
+				batch := NewRecordBatch(make(chan struct{}, 1))
+				record := NewRecord("", false)
+				record.Header.Set("WARC-Type", "resource")
+				record.Content.Write(content)
+				batch.Records = append(batch.Records, record)
+
+				records <- batch
+				<-batch.FeedbackChan
+			}
+			closeTestRotator(records, doneChannels)
+
+			if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); len(counts) < 2 {
+				t.Errorf("expected the files to be rotated, got %v", counts)
+			}
+
+			checkMaxFileSize(t, rotatorSettings.OutputDirectory, 8192)
+		})
+	}
+}
+
+// checkMaxFileSize fails if a closed WARC file of dir holding more than one
+// record, warcinfo excluded, is larger than maxSize.
+func checkMaxFileSize(t *testing.T, dir string, maxSize int64) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.warc*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".open") {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() <= maxSize {
+			continue
+		}
+
+		if count := countFileRecords(t, path); count != 1 {
+			t.Errorf("%s: %d bytes for %d records, above the maximum of %d bytes", filepath.Base(path), info.Size(), count, maxSize)
+		}
+	}
+}
+
+func TestRotatorMaxAge(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.WarcMaxAge = 100 * time.Millisecond
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 1, 2, 10)
+
+	// The file is closed without any other record being written
+	deadline := time.Now().Add(5 * time.Second)
+	for len(countRecordsPerFile(t, rotatorSettings.OutputDirectory)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the WARC file wasn't rotated after WarcMaxAge")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The new file is empty, so it isn't rotated
+	time.Sleep(300 * time.Millisecond)
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); len(counts) != 1 || counts[0] != 2 {
+		t.Errorf("expected 1 file of 2 records, got %v", counts)
+	}
+
+	closeTestRotator(records, doneChannels)
+}
+
+func TestRotatorRotate(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.WARCWriterPoolSize = 2
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 1, 2, 10)
+
+	// Only the writer that received the batch has a file to close
+	rotatorSettings.Rotate()
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); len(counts) != 1 || counts[0] != 2 {
+		t.Fatalf("expected 1 closed file of 2 records, got %v", counts)
+	}
+
+	rotatorSettings.Rotate()
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); len(counts) != 1 {
+		t.Fatalf("empty files shouldn't be rotated, got %v", counts)
+	}
+
+	closeTestRotator(records, doneChannels)
+
+	// Rotating a closed rotator doesn't block
+	rotatorSettings.Rotate()
+
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); len(counts) != 3 {
+		t.Errorf("expected 3 files once closed, got %v", counts)
+	}
+}
+
+func TestRotatorHooks(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		opened          []WARCFileInfo
+		closed          []WARCFileInfo
+		mutex           sync.Mutex
+	)
+
+	rotatorSettings.OnFileOpened = func(info WARCFileInfo) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		opened = append(opened, info)
+	}
+
+	rotatorSettings.OnFileClosed = func(info WARCFileInfo) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		closed = append(closed, info)
+	}
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBatches(t, records, 1, 2, 10)
+	closeTestRotator(records, doneChannels)
+
+	if len(opened) != 1 || len(closed) != 1 {
+		t.Fatalf("expected 1 opened and 1 closed file, got %d and %d", len(opened), len(closed))
+	}
+
+	if !strings.HasSuffix(opened[0].Path, ".open") || opened[0].Path != closed[0].Path+".open" {
+		t.Errorf("unexpected paths %q and %q", opened[0].Path, closed[0].Path)
+	}
+
+	if opened[0].WarcinfoID == "" || opened[0].WarcinfoID != closed[0].WarcinfoID {
+		t.Errorf("unexpected warcinfo IDs %q and %q", opened[0].WarcinfoID, closed[0].WarcinfoID)
+	}
+
+	data, err := os.ReadFile(closed[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if closed[0].Size != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), closed[0].Size)
+	}
+
+	if digest := sha256.Sum256(data); closed[0].SHA256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("expected SHA-256 %x, got %s", digest, closed[0].SHA256)
+	}
+
+	if closed[0].Records != 2 {
+		t.Errorf("expected 2 records, got %d", closed[0].Records)
+	}
+
+	// The records refer to the warcinfo record of their file
+	file, err := os.Open(closed[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		record, eol, err := reader.ReadRecord()
+		if eol {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Header.Get("WARC-Type") == "warcinfo" && record.Header.Get("WARC-Record-ID") != closed[0].WarcinfoID {
+			t.Errorf("expected warcinfo ID %s, got %s", record.Header.Get("WARC-Record-ID"), closed[0].WarcinfoID)
+		}
+		record.Content.Close()
+	}
+}
+
+func TestRotatorSyncPolicy(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncNone, SyncEveryBatch, SyncEveryBytes} {
+		rotatorSettings := defaultRotatorSettings(t)
+		rotatorSettings.SyncPolicy = policy
+		rotatorSettings.SyncBytes = 1 << 20
+
+		records, doneChannels, err := rotatorSettings.NewWARCRotator()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The feedback of every batch is signalled without waiting for the file to be closed
+		writeTestBatches(t, records, 3, 2, 10)
+		closeTestRotator(records, doneChannels)
+
+		if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); !slices.Equal(counts, []int{6}) {
+			t.Errorf("policy %d: expected 1 file of 6 records, got %v", policy, counts)
+		}
+	}
+
+	// With SyncOnClose, the feedback is only signalled once the file is finalized
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.SyncPolicy = SyncOnClose
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewRecordBatch(make(chan struct{}, 1))
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "resource")
+	record.Content.Write([]byte("content"))
+	batch.Records = []*Record{record}
+
+	records <- batch
+
+	select {
+	case <-batch.FeedbackChan:
+		t.Fatal("the feedback was signalled before the file was closed")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	rotatorSettings.Rotate()
+
+	select {
+	case <-batch.FeedbackChan:
+	default:
+		t.Fatal("the feedback wasn't signalled once the file was closed")
+	}
+
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); !slices.Equal(counts, []int{1}) {
+		t.Errorf("expected 1 finalized file of 1 record, got %v", counts)
+	}
+
+	closeTestRotator(records, doneChannels)
+}
+
+// blockingSyncStorage is a MemoryStorage which files don't sync until release is closed.
+type blockingSyncStorage struct {
+	*MemoryStorage
+	release chan struct{}
+}
+
+func (s *blockingSyncStorage) Create(name string) (StorageFile, error) {
+	file, err := s.MemoryStorage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &blockingSyncFile{StorageFile: file, release: s.release}, nil
+}
+
+type blockingSyncFile struct {
+	StorageFile
+	release chan struct{}
+}
+
+func (f *blockingSyncFile) Sync() error {
+	<-f.release
+	return f.StorageFile.Sync()
+}
+
+func TestRotatorSyncEveryBatchWriteRecordFeedback(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		storage         = &blockingSyncStorage{MemoryStorage: NewMemoryStorage(), release: make(chan struct{})}
+	)
+
+	rotatorSettings.Storage = storage
+	rotatorSettings.SyncPolicy = SyncEveryBatch
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch of CustomHTTPClient.WriteRecord still waits for the sync
+	batch := NewRecordBatch(make(chan struct{}, 1))
+	batch.feedbackOnWrite = true
+	record := NewRecord("", false)
+	record.Header.Set("WARC-Type", "resource")
+	record.Content.Write([]byte("content"))
+	batch.Records = []*Record{record}
+
+	records <- batch
+
+	select {
+	case <-batch.FeedbackChan:
+		t.Fatal("the feedback was signalled before the file was synced")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(storage.release)
+
+	select {
+	case <-batch.FeedbackChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the feedback wasn't signalled once the file was synced")
+	}
+
+	closeTestRotator(records, doneChannels)
+}
+
+func TestRotatorSyncPolicySettings(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.SyncPolicy = SyncEveryBytes
+
+	if _, _, err := rotatorSettings.NewWARCRotator(); err == nil {
+		t.Error("expected an error when SyncBytes isn't set with SyncEveryBytes")
+	}
+
+	rotatorSettings.SyncPolicy = SyncPolicy(42)
+
+	if _, _, err := rotatorSettings.NewWARCRotator(); err == nil {
+		t.Error("expected an error with an invalid sync policy")
+	}
+}
+
+func TestRotatorPriorityQueue(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		storage         = NewMemoryStorage()
+		metrics         = NewCaptureMetrics()
+		blocked         = make(chan struct{})
+		release         = make(chan struct{})
+		blockOnce       sync.Once
+	)
+
+	rotatorSettings.Storage = storage
+	rotatorSettings.Metrics = metrics
+	rotatorSettings.FilenameTemplate = "{prefix}-{serial:5}"
+	rotatorSettings.WarcMaxRecords = 1
+	rotatorSettings.QueueSize = 2
+	rotatorSettings.PriorityQueueSize = 1
+
+	// The writer is held while closing its first file, so that batches pile up in the queues
+	rotatorSettings.OnFileClosed = func(WARCFileInfo) {
+		blockOnce.Do(func() {
+			close(blocked)
+			<-release
+		})
+	}
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(uri string, priority bool) {
+		record := NewRecord("", false)
+		record.Header.Set("WARC-Type", "resource")
+		record.Header.Set("WARC-Target-URI", uri)
+		record.Content.Write([]byte(uri))
+
+		batch := NewRecordBatch(nil)
+		batch.Records = []*Record{record}
+
+		if err := rotatorSettings.SendBatch(context.Background(), batch, priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send("http://a/", false)
+	send("http://b/", false)
+	<-blocked
+
+	send("http://c/", false)
+	send("http://d/", false)
+	send("http://priority/", true)
+
+	// The depth is up to date while the writer is held
+	metrics.mutex.Lock()
+	depth := metrics.queueDepth
+	metrics.mutex.Unlock()
+
+	if depth != 3 {
+		t.Errorf("expected a queue depth of 3 while the writer is held, got %d", depth)
+	}
+
+	close(release)
+
+	closeTestRotator(records, doneChannels)
+
+	names, err := storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uris []string
+	for _, name := range names {
+		data, err := storage.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewReader(io.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if record.Header.Get("WARC-Type") == "resource" {
+				uris = append(uris, record.Header.Get("WARC-Target-URI"))
+			}
+			record.Content.Close()
+		}
+	}
+
+	expected := []string{"http://a/", "http://b/", "http://priority/", "http://c/", "http://d/"}
+	if !slices.Equal(uris, expected) {
+		t.Errorf("expected records in order %v, got %v", expected, uris)
+	}
+
+	var text strings.Builder
+	if err := metrics.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`warc_writer_queue_wait_seconds_count{lane="default"} 4`,
+		`warc_writer_queue_wait_seconds_count{lane="priority"} 1`,
+	} {
+		if !strings.Contains(text.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, text.String())
+		}
+	}
+}
+
+func TestRotatorParallelGZIP(t *testing.T) {
+	rotatorSettings := defaultRotatorSettings(t)
+	rotatorSettings.ParallelGZIP = true
+	rotatorSettings.ParallelGZIPMinSize = 100 << 10
+	rotatorSettings.ParallelGZIPBlockSize = 64 << 10
+	rotatorSettings.ParallelGZIPBlocks = 4
+
+	records, doneChannels, err := rotatorSettings.NewWARCRotator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A large record, compressed in parallel, and a small one which isn't
+	writeTestBatches(t, records, 1, 1, 1<<20)
+	writeTestBatches(t, records, 1, 1, 10)
+	closeTestRotator(records, doneChannels)
+
+	if counts := countRecordsPerFile(t, rotatorSettings.OutputDirectory); !slices.Equal(counts, []int{2}) {
+		t.Fatalf("expected 1 file of 2 records, got %v", counts)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(rotatorSettings.OutputDirectory, "*.warc.gz"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v (%v)", paths, err)
+	}
+
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Every record, warcinfo included, is a single GZIP member
+	if _, members := lastCompleteGZIPMember(file); members != 3 {
+		t.Errorf("expected 3 GZIP members, got %d", members)
+	}
+}
+
+func benchmarkWriteLargeRecord(b *testing.B, newWriter func(io.Writer) (*Writer, error)) {
+	content := make([]byte, 32<<20)
+	for i := range content {
+		content[i] = byte(i * i >> 7)
+	}
+
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		record := NewRecord("", false)
+		record.Header.Set("WARC-Type", "resource")
+		record.Content.Write(content)
+		b.StartTimer()
+
+		writer, err := newWriter(io.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if _, err := writer.WriteRecord(record); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := writer.CloseCompressedWriter(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteLargeRecordGZIP(b *testing.B) {
+	benchmarkWriteLargeRecord(b, func(w io.Writer) (*Writer, error) {
+		return NewWriter(w, "bench.warc.gz", "GZIP", "", false, nil)
+	})
+}
+
+func BenchmarkWriteLargeRecordParallelGZIP(b *testing.B) {
+	benchmarkWriteLargeRecord(b, func(w io.Writer) (*Writer, error) {
+		return NewParallelGZIPWriter(w, "bench.warc.gz", 0, 1<<20, runtime.GOMAXPROCS(0))
+	})
+}
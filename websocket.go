@@ -0,0 +1,228 @@
+package warc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebSocket connections are captured in two steps. The opening handshake is
+// written as a regular request/response pair, then every frame exchanged on
+// the connection is written as a resource record, containing the unmasked
+// frame payload, and a metadata record describing it (direction, opcode,
+// flags and timestamp) that refers to the resource record. Both records are
+// concurrent to the handshake's response record and their WARC-Date is the
+// time the frame started to arrive.
+
+const (
+	// WebSocketClientToServer is the direction of frames sent by the client
+	WebSocketClientToServer = "client-to-server"
+	// WebSocketServerToClient is the direction of frames sent by the server
+	WebSocketServerToClient = "server-to-client"
+)
+
+// webSocketOpcodes maps the opcodes defined in RFC 6455, section 5.2, to their name.
+var webSocketOpcodes = map[byte]string{
+	0x0: "continuation",
+	0x1: "text",
+	0x2: "binary",
+	0x8: "close",
+	0x9: "ping",
+	0xA: "pong",
+}
+
+// webSocketFrameHeader is the header of a single WebSocket frame (RFC 6455, section 5.2).
+type webSocketFrameHeader struct {
+	maskingKey [4]byte
+	length     uint64
+	opcode     byte
+	fin        bool
+	rsv1       bool
+	rsv2       bool
+	rsv3       bool
+	masked     bool
+}
+
+// isWebSocketUpgrade returns true if the given HTTP headers ask for, or accept, an upgrade to WebSocket.
+func isWebSocketUpgrade(header http.Header) bool {
+	if !strings.EqualFold(strings.TrimSpace(header.Get("Upgrade")), "websocket") {
+		return false
+	}
+
+	for _, value := range header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// webSocketTargetURI returns the ws:// or wss:// URI of a WebSocket opened with an HTTP(S) request to targetURI.
+func webSocketTargetURI(targetURI string) string {
+	if strings.HasPrefix(targetURI, "https://") {
+		return "wss://" + strings.TrimPrefix(targetURI, "https://")
+	}
+
+	return "ws://" + strings.TrimPrefix(targetURI, "http://")
+}
+
+func readWebSocketFrameHeader(r io.Reader) (header webSocketFrameHeader, err error) {
+	var buf [8]byte
+
+	if _, err = io.ReadFull(r, buf[:2]); err != nil {
+		return header, err
+	}
+
+	header.fin = buf[0]&0x80 != 0
+	header.rsv1 = buf[0]&0x40 != 0
+	header.rsv2 = buf[0]&0x20 != 0
+	header.rsv3 = buf[0]&0x10 != 0
+	header.opcode = buf[0] & 0x0F
+	header.masked = buf[1]&0x80 != 0
+	header.length = uint64(buf[1] & 0x7F)
+
+	switch header.length {
+	case 126:
+		if _, err = io.ReadFull(r, buf[:2]); err != nil {
+			return header, io.ErrUnexpectedEOF
+		}
+		header.length = uint64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err = io.ReadFull(r, buf[:8]); err != nil {
+			return header, io.ErrUnexpectedEOF
+		}
+		header.length = binary.BigEndian.Uint64(buf[:8])
+	}
+
+	if header.masked {
+		if _, err = io.ReadFull(r, header.maskingKey[:]); err != nil {
+			return header, io.ErrUnexpectedEOF
+		}
+	}
+
+	return header, nil
+}
+
+// webSocketUnmasker unmasks a frame payload as it is read (RFC 6455, section 5.3).
+type webSocketUnmasker struct {
+	r   io.Reader
+	key [4]byte
+	pos int
+}
+
+func (u *webSocketUnmasker) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= u.key[u.pos%4]
+		u.pos++
+	}
+
+	return n, err
+}
+
+// captureWebSocketFrames reads frames from r until the connection is closed and sends
+// them to the WARC writer, each frame in its own batch.
+func (d *customDialer) captureWebSocketFrames(ctx context.Context, r *bufio.Reader, direction, targetURI, handshakeID, IP string) error {
+	targetURI = webSocketTargetURI(targetURI)
+
+	for {
+		// The frame timestamp is the time its first byte is available
+		if _, err := r.Peek(1); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("captureWebSocketFrames: %s", err.Error())
+		}
+
+		timestamp := time.Now().UTC()
+
+		header, err := readWebSocketFrameHeader(r)
+		if err != nil {
+			return fmt.Errorf("captureWebSocketFrames: reading frame header failed: %s", err.Error())
+		}
+
+		var payload io.Reader = io.LimitReader(r, int64(header.length))
+		if header.masked {
+			payload = &webSocketUnmasker{r: payload, key: header.maskingKey}
+		}
+
+		resourceRecord := NewRecord(d.client.TempDir, d.client.FullOnDisk)
+		written, err := io.Copy(resourceRecord.Content, payload)
+		if err != nil {
+			resourceRecord.Content.Close()
+			return fmt.Errorf("captureWebSocketFrames: reading frame payload failed: %s", err.Error())
+		}
+
+		if uint64(written) != header.length {
+			resourceRecord.Content.Close()
+			return fmt.Errorf("captureWebSocketFrames: reading frame payload failed: %w", io.ErrUnexpectedEOF)
+		}
+
+		resourceID := uuid.NewString()
+		resourceRecord.Header.Set("WARC-Type", "resource")
+		resourceRecord.Header.Set("WARC-Record-ID", "<urn:uuid:"+resourceID+">")
+		resourceRecord.Header.Set("WARC-Target-URI", targetURI)
+		resourceRecord.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+handshakeID+">")
+
+		// Fragmented and compressed (permessage-deflate) payloads aren't guaranteed to be valid UTF-8
+		if header.opcode == 0x1 && header.fin && !header.rsv1 {
+			resourceRecord.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			resourceRecord.Header.Set("Content-Type", "application/octet-stream")
+		}
+
+		metadataRecord := NewRecord(d.client.TempDir, d.client.FullOnDisk)
+		metadataRecord.Header.Set("WARC-Type", "metadata")
+		metadataRecord.Header.Set("WARC-Target-URI", targetURI)
+		metadataRecord.Header.Set("WARC-Refers-To", "<urn:uuid:"+resourceID+">")
+		metadataRecord.Header.Set("WARC-Concurrent-To", "<urn:uuid:"+handshakeID+">")
+		metadataRecord.Header.Set("Content-Type", "application/warc-fields")
+
+		opcodeName, ok := webSocketOpcodes[header.opcode]
+		if !ok {
+			opcodeName = "reserved"
+		}
+
+		fmt.Fprintf(metadataRecord.Content, "direction: %s\r\n", direction)
+		fmt.Fprintf(metadataRecord.Content, "opcode: %d\r\n", header.opcode)
+		fmt.Fprintf(metadataRecord.Content, "opcode-name: %s\r\n", opcodeName)
+		fmt.Fprintf(metadataRecord.Content, "fin: %s\r\n", strconv.FormatBool(header.fin))
+		fmt.Fprintf(metadataRecord.Content, "rsv1: %s\r\n", strconv.FormatBool(header.rsv1))
+		fmt.Fprintf(metadataRecord.Content, "rsv2: %s\r\n", strconv.FormatBool(header.rsv2))
+		fmt.Fprintf(metadataRecord.Content, "rsv3: %s\r\n", strconv.FormatBool(header.rsv3))
+		fmt.Fprintf(metadataRecord.Content, "masked: %s\r\n", strconv.FormatBool(header.masked))
+		fmt.Fprintf(metadataRecord.Content, "payload-length: %d\r\n", header.length)
+		fmt.Fprintf(metadataRecord.Content, "timestamp: %s\r\n", timestamp.Format(time.RFC3339Nano))
+
+		for _, record := range []*Record{resourceRecord, metadataRecord} {
+			if IP != "" {
+				record.Header.Set("WARC-IP-Address", IP)
+			}
+
+			record.Header.Set("WARC-Block-Digest", "sha1:"+GetSHA1(record.Content))
+			record.Header.Set("Content-Length", strconv.Itoa(getContentLength(record.Content)))
+		}
+
+		batch := NewRecordBatch(nil)
+		batch.CaptureTime = timestamp.Format(time.RFC3339Nano)
+		batch.Records = []*Record{resourceRecord, metadataRecord}
+
+		if err := d.client.rotatorSettings.SendBatch(ctx, batch, false); err != nil {
+			resourceRecord.Content.Close()
+			metadataRecord.Content.Close()
+			io.Copy(io.Discard, r)
+			return err
+		}
+	}
+}
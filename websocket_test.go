@@ -0,0 +1,236 @@
+package warc
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeWebSocketFrame writes a single, final, frame with the given opcode, masked if a key is given.
+func writeWebSocketFrame(t *testing.T, w io.Writer, opcode byte, payload []byte, maskingKey []byte) {
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+
+	if maskingKey != nil {
+		frame[1] |= 0x80
+		frame = append(frame, maskingKey...)
+
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ maskingKey[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := w.Write(append(frame, payload...)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	testCases := []struct {
+		header   http.Header
+		expected bool
+	}{
+		{http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}}, true},
+		{http.Header{"Upgrade": {"WebSocket"}, "Connection": {"keep-alive, Upgrade"}}, true},
+		{http.Header{"Upgrade": {"websocket"}}, false},
+		{http.Header{"Upgrade": {"h2c"}, "Connection": {"Upgrade"}}, false},
+		{http.Header{"Connection": {"Upgrade"}}, false},
+	}
+
+	for _, tc := range testCases {
+		if isWebSocketUpgrade(tc.header) != tc.expected {
+			t.Errorf("isWebSocketUpgrade(%v) should be %v", tc.header, tc.expected)
+		}
+	}
+}
+
+func TestHTTPClientWebSocket(t *testing.T) {
+	var (
+		rotatorSettings = defaultRotatorSettings(t)
+		errWg           sync.WaitGroup
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r.Header) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+		rw.Flush()
+
+		header, err := readWebSocketFrameHeader(rw)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err = io.CopyN(io.Discard, rw, int64(header.length)); err != nil {
+			t.Error(err)
+			return
+		}
+
+		writeWebSocketFrame(t, conn, 0x1, []byte("world"), nil)
+		writeWebSocketFrame(t, conn, 0x8, []byte{0x03, 0xE8}, nil)
+	}))
+	defer server.Close()
+
+	httpClient, err := NewWARCWritingHTTPClient(HTTPClientSettings{RotatorSettings: rotatorSettings})
+	if err != nil {
+		t.Fatalf("Unable to init WARC writing HTTP client: %s", err)
+	}
+
+	errWg.Add(1)
+	go func() {
+		defer errWg.Done()
+		for err := range httpClient.ErrChan {
+			t.Errorf("Error writing to WARC: %s", err.Err.Error())
+		}
+	}()
+
+	req, err := http.NewRequest("GET", server.URL+"/socket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 Switching Protocols, got %s", resp.Status)
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatal("the response body of a 101 response should be writable")
+	}
+
+	writeWebSocketFrame(t, conn, 0x1, []byte("hello"), []byte{0x01, 0x02, 0x03, 0x04})
+
+	if _, err = io.Copy(io.Discard, conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	httpClient.Close()
+	errWg.Wait()
+
+	files, err := filepath.Glob(rotatorSettings.OutputDirectory + "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		records    = make(map[string][]*Record)
+		resources  = make(map[string]string)
+		directions = make(map[string]string)
+	)
+
+	for _, path := range files {
+		testFileHash(t, path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			record, eol, err := reader.ReadRecord()
+			if eol {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := io.ReadAll(record.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch record.Header.Get("WARC-Type") {
+			case "resource":
+				resources[record.Header.Get("WARC-Record-ID")] = string(content)
+			case "metadata":
+				for _, line := range strings.Split(string(content), "\r\n") {
+					if direction, found := strings.CutPrefix(line, "direction: "); found {
+						directions[record.Header.Get("WARC-Refers-To")] = direction
+					}
+				}
+			}
+
+			records[record.Header.Get("WARC-Type")] = append(records[record.Header.Get("WARC-Type")], record)
+			record.Content.Close()
+		}
+	}
+
+	if len(records["request"]) != 1 || len(records["response"]) != 1 {
+		t.Fatalf("expected a single request/response pair for the handshake, got %d requests and %d responses", len(records["request"]), len(records["response"]))
+	}
+
+	// The client sent one frame, the server replied with a text frame and a close frame
+	if len(records["resource"]) != 3 || len(records["metadata"]) != 3 {
+		t.Fatalf("expected 3 resource and 3 metadata records, got %d and %d", len(records["resource"]), len(records["metadata"]))
+	}
+
+	handshakeID := records["response"][0].Header.Get("WARC-Record-ID")
+	if records["response"][0].Header.Get("WARC-Concurrent-To") != records["request"][0].Header.Get("WARC-Record-ID") {
+		t.Error("the handshake request and response should be concurrent")
+	}
+
+	expectedURI := "ws://" + strings.TrimPrefix(server.URL, "http://") + "/socket"
+	for _, record := range append(records["resource"], records["metadata"]...) {
+		if record.Header.Get("WARC-Target-URI") != expectedURI {
+			t.Errorf("unexpected WARC-Target-URI %s, expected %s", record.Header.Get("WARC-Target-URI"), expectedURI)
+		}
+
+		if record.Header.Get("WARC-Concurrent-To") != handshakeID {
+			t.Errorf("frame records should be concurrent to the handshake response %s, got %s", handshakeID, record.Header.Get("WARC-Concurrent-To"))
+		}
+	}
+
+	var found int
+	for id, content := range resources {
+		switch {
+		case content == "hello" && directions[id] == WebSocketClientToServer:
+			found++
+		case content == "world" && directions[id] == WebSocketServerToClient:
+			found++
+		}
+	}
+
+	if found != 2 {
+		t.Errorf("unexpected frames %v with directions %v", resources, directions)
+	}
+}
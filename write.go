@@ -10,6 +10,8 @@ import (
 
 	"github.com/CorentinB/warc/pkg/spooledtempfile"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
 
 	"github.com/google/uuid"
 	"github.com/klauspost/compress/zstd"
@@ -23,6 +25,15 @@ type Writer struct {
 	FileName     string
 	Compression  string
 	ParallelGZIP bool
+	// ParallelGZIPWriter is set instead of GZIPWriter when ParallelGZIP is true,
+	// see NewParallelGZIPWriter
+	ParallelGZIPWriter *pgzip.Writer
+	// XZWriter is set when Compression is XZ
+	XZWriter *xz.Writer
+	// Version is the version of the records written, default is WARCVersion11
+	Version WARCVersion
+	// underlying is the writer the compressed streams are written to
+	underlying io.Writer
 }
 
 // RecordBatch is a structure that contains a bunch of
@@ -33,6 +44,12 @@ type RecordBatch struct {
 	FeedbackChan chan struct{}
 	CaptureTime  string
 	Records      []*Record
+	// queuedAt is when the batch was sent with SendBatch, to measure its wait
+	queuedAt time.Time
+	// feedbackOnWrite signals FeedbackChan as soon as the records are written
+	// with SyncOnClose, rather than when the file is closed, for senders blocking
+	// on it like WriteRecord
+	feedbackOnWrite bool
 }
 
 // Record represents a WARC record.
@@ -40,6 +57,9 @@ type Record struct {
 	Header  Header
 	Content spooledtempfile.ReadWriteSeekCloser
 	Version string // WARC/1.0, WARC/1.1 ...
+	// rawHeader are the header lines of a record read by Reader, in order and
+	// with repeated fields, so that it can be copied verbatim
+	rawHeader []string
 }
 
 // WriteRecord writes a record to the underlying WARC file.
@@ -55,11 +75,22 @@ type Record struct {
 func (w *Writer) WriteRecord(r *Record) (recordID string, err error) {
 	defer r.Content.Close()
 
-	var written int64
+	version := w.Version.orDefault()
 
 	// Add the mandatories headers
 	if r.Header.Get("WARC-Date") == "" {
-		r.Header.Set("WARC-Date", time.Now().UTC().Format(time.RFC3339Nano))
+		r.Header.Set("WARC-Date", version.FormatDate(time.Now()))
+	}
+
+	// Dates and revisit profiles are written as the version defines them
+	r.Header.Set("WARC-Date", version.normalizeDate(r.Header.Get("WARC-Date")))
+
+	if date := r.Header.Get("WARC-Refers-To-Date"); date != "" {
+		r.Header.Set("WARC-Refers-To-Date", version.normalizeDate(date))
+	}
+
+	if profile := r.Header.Get("WARC-Profile"); profile != "" {
+		r.Header.Set("WARC-Profile", version.revisitProfile(profile))
 	}
 
 	if r.Header.Get("WARC-Type") == "" {
@@ -71,7 +102,7 @@ func (w *Writer) WriteRecord(r *Record) (recordID string, err error) {
 		r.Header.Set("WARC-Record-ID", "<urn:uuid:"+recordID+">")
 	}
 
-	if _, err := io.WriteString(w.FileWriter, "WARC/1.1\r\n"); err != nil {
+	if _, err := io.WriteString(w.FileWriter, string(version)+"\r\n"); err != nil {
 		return recordID, err
 	}
 
@@ -96,14 +127,10 @@ func (w *Writer) WriteRecord(r *Record) (recordID string, err error) {
 	}
 
 	r.Content.Seek(0, 0)
-	if written, err = io.Copy(w.FileWriter, r.Content); err != nil {
+	if _, err = io.Copy(w.FileWriter, r.Content); err != nil {
 		return recordID, err
 	}
 
-	if written > 0 {
-		DataTotal.Incr(written)
-	}
-
 	if _, err := io.WriteString(w.FileWriter, "\r\n\r\n"); err != nil {
 		return recordID, err
 	}
@@ -120,7 +147,7 @@ func (w *Writer) WriteInfoRecord(payload map[string]string) (recordID string, er
 	infoRecord := NewRecord("", false)
 
 	// Set the headers
-	infoRecord.Header.Set("WARC-Date", time.Now().UTC().Format(time.RFC3339Nano))
+	infoRecord.Header.Set("WARC-Date", w.Version.FormatDate(time.Now()))
 	infoRecord.Header.Set("WARC-Filename", strings.TrimSuffix(w.FileName, ".open"))
 	infoRecord.Header.Set("WARC-Type", "warcinfo")
 	infoRecord.Header.Set("Content-Type", "application/warc-fields")